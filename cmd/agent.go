@@ -0,0 +1,485 @@
+package cmd
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/segmentio/chamber/store"
+	"github.com/spf13/cobra"
+)
+
+var (
+	agentRefresh time.Duration
+
+	// agentCmd represents the agent command
+	agentCmd = &cobra.Command{
+		Use:   "agent <service...>",
+		Short: "Keep the secrets of services cached for exec and read",
+		Long: `Keep the secrets of services cached for exec and read.
+
+The agent reads the secrets of the given services at startup and then every
+--refresh interval, and serves them over a unix socket.  While it runs, "chamber
+exec" and "chamber read --quiet" read the secrets of those services from the
+agent instead of the secret store.
+
+The socket is $CHAMBER_AGENT_SOCKET, or ~/.chamber/agent-<scope>.sock by
+default, where the scope is derived from the AWS profile, role, region, config
+profile and CHAMBER_NO_PATHS.  Commands only read from an agent started with
+the same settings, and commands that change a served service make the agent
+read it again.  The agent only keeps the values and types of secrets, so
+read without --quiet always reads from the secret store.  Set
+CHAMBER_NO_AGENT to make exec and read ignore a running agent.`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: agent,
+	}
+)
+
+func init() {
+	agentCmd.Flags().DurationVar(&agentRefresh, "refresh", time.Minute, "How often secrets are read again from the secret store")
+	RootCmd.AddCommand(agentCmd)
+}
+
+func agent(cmd *cobra.Command, args []string) error {
+	if agentRefresh <= 0 {
+		return errors.Errorf("--refresh must be a positive duration, got %s", agentRefresh)
+	}
+
+	socket := agentSocket()
+	if socket == "" {
+		return errors.New("CHAMBER_AGENT_SOCKET must be set when HOME is not")
+	}
+
+	services := []string{}
+	for _, service := range args {
+		service = strings.ToLower(service)
+		if err := validateService(service); err != nil {
+			return errors.Wrapf(err, "Failed to validate service %s", service)
+		}
+		services = append(services, service)
+	}
+
+	cache := &agentCache{services: map[string][]store.RawSecret{}}
+	secretStore := getSecretStore()
+	if err := cache.refresh(secretStore, services); err != nil {
+		return errors.Wrap(err, "Failed to read secrets")
+	}
+	ticker := time.NewTicker(agentRefresh)
+	defer ticker.Stop()
+	go func() {
+		for range ticker.C {
+			if err := cache.refresh(secretStore, services); err != nil {
+				log.Printf("failed to refresh secrets, serving stale secrets: %s", err)
+			}
+		}
+	}()
+
+	if err := os.MkdirAll(filepath.Dir(socket), 0700); err != nil {
+		return errors.Wrap(err, "Failed to create socket directory")
+	}
+	listener, err := listen("unix:" + socket)
+	if err != nil {
+		return errors.Wrap(err, "Failed to listen")
+	}
+
+	log.Printf("serving secrets of %s on %s", strings.Join(services, ", "), socket)
+	return serveHTTP(listener, newAgentHandler(cache, secretStore, services, agentScope()))
+}
+
+// agentScopeHeader is the response header carrying the scope of the agent
+const agentScopeHeader = "Chamber-Agent-Scope"
+
+// agentScope identifies the secrets the current settings read: the AWS
+// credentials and region, the config profile and whether secrets are named
+// by path.  Commands only read from an agent of the same scope.
+func agentScope() string {
+	profile := awsProfile
+	if profile == "" {
+		profile = os.Getenv("AWS_PROFILE")
+	}
+	accessKey := ""
+	if profile == "" {
+		accessKey = os.Getenv("AWS_ACCESS_KEY_ID")
+	}
+	chamberConfigProfile := configProfile
+	if chamberConfigProfile == "" {
+		chamberConfigProfile = os.Getenv("CHAMBER_CONFIG_PROFILE")
+	}
+
+	settings := []string{
+		profile,
+		accessKey,
+		roleARN,
+		externalID,
+		mfaSerial,
+		os.Getenv("CHAMBER_AWS_REGION"),
+		config.Region,
+		os.Getenv("AWS_REGION"),
+		os.Getenv("AWS_DEFAULT_REGION"),
+		chamberConfigProfile,
+		fmt.Sprint(noPaths()),
+	}
+	sum := sha256.Sum256([]byte(strings.Join(settings, "\x00")))
+	return hex.EncodeToString(sum[:8])
+}
+
+// agentSocket returns the path of the agent's unix socket, which by default
+// depends on the scope so that agents of different scopes can run side by
+// side
+func agentSocket() string {
+	if socket := os.Getenv("CHAMBER_AGENT_SOCKET"); socket != "" {
+		return socket
+	}
+	if home := os.Getenv("HOME"); home != "" {
+		return filepath.Join(home, ".chamber", "agent-"+agentScope()+".sock")
+	}
+	return ""
+}
+
+// agentHandler serves the secrets of the agent, lists them with their types
+// on /v1/raw/<service>, and reads a service again when a command that changed
+// it posts to /v1/refresh/<service>
+type agentHandler struct {
+	cache       *agentCache
+	secretStore store.Store
+	scope       string
+	secrets     http.Handler
+}
+
+func newAgentHandler(cache *agentCache, secretStore store.Store, services []string, scope string) http.Handler {
+	return &agentHandler{
+		cache:       cache,
+		secretStore: secretStore,
+		scope:       scope,
+		secrets: &secretsHandler{
+			store:     cache,
+			anonymous: &serveClient{Name: "agent", Services: services},
+		},
+	}
+}
+
+func (h *agentHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set(agentScopeHeader, h.scope)
+	if strings.HasPrefix(r.URL.Path, "/v1/raw/") {
+		h.serveRaw(w, r)
+		return
+	}
+	if !strings.HasPrefix(r.URL.Path, "/v1/refresh/") {
+		h.secrets.ServeHTTP(w, r)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		httpError(w, http.StatusMethodNotAllowed)
+		return
+	}
+	service := strings.ToLower(strings.TrimPrefix(r.URL.Path, "/v1/refresh/"))
+	if !h.cache.serves(service) {
+		httpError(w, http.StatusNotFound)
+		return
+	}
+	if err := h.cache.refresh(h.secretStore, []string{service}); err != nil {
+		log.Printf("failed to refresh secrets, serving stale secrets: %s", err)
+		httpError(w, http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// agentSecret is a secret listed by the agent, along with its type so that
+// StringList and binary values are handled as when read from the store
+type agentSecret struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+	Type  string `json:"type,omitempty"`
+}
+
+func (h *agentHandler) serveRaw(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		httpError(w, http.StatusMethodNotAllowed)
+		return
+	}
+	service := strings.ToLower(strings.TrimPrefix(r.URL.Path, "/v1/raw/"))
+	if !h.cache.serves(service) {
+		httpError(w, http.StatusNotFound)
+		return
+	}
+
+	rawSecrets, _ := h.cache.ListRaw(service)
+	secrets := []agentSecret{}
+	for _, rawSecret := range rawSecrets {
+		secrets = append(secrets, agentSecret{
+			Key:   key(rawSecret.Key),
+			Value: rawSecret.Value,
+			Type:  rawSecret.Type,
+		})
+	}
+	writeJSON(w, secrets)
+}
+
+// agentCache holds the latest values of the secrets of the services served
+// by the agent.  Only Read and ListRaw are implemented.
+type agentCache struct {
+	store.Store
+
+	mu       sync.RWMutex
+	services map[string][]store.RawSecret
+}
+
+// refresh reads the latest values of the secrets of services from
+// secretStore.  Services that fail to be read keep their previous secrets.
+func (c *agentCache) refresh(secretStore store.Store, services []string) error {
+	var lastErr error
+	for _, service := range services {
+		secrets, err := secretStore.ListRaw(service)
+		if err != nil {
+			lastErr = errors.Wrapf(err, "Failed to list store contents for service %s", service)
+			continue
+		}
+		c.mu.Lock()
+		c.services[service] = secrets
+		c.mu.Unlock()
+	}
+	return lastErr
+}
+
+// serves returns whether the agent serves the secrets of service
+func (c *agentCache) serves(service string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	_, ok := c.services[service]
+	return ok
+}
+
+func (c *agentCache) Read(id store.SecretId, version int) (store.Secret, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for _, rawSecret := range c.services[id.Service] {
+		if key(rawSecret.Key) == id.Key {
+			value := rawSecret.Value
+			return store.Secret{
+				Value: &value,
+				Meta:  store.SecretMetadata{Key: rawSecret.Key, Type: rawSecret.Type},
+			}, nil
+		}
+	}
+	return store.Secret{}, store.ErrSecretNotFound
+}
+
+func (c *agentCache) ListRaw(service string) ([]store.RawSecret, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	rawSecrets := make([]store.RawSecret, len(c.services[service]))
+	copy(rawSecrets, c.services[service])
+	return rawSecrets, nil
+}
+
+// agentStore reads the latest secrets from a running agent, falling back to
+// the secret store when no agent of the current scope is running or the
+// agent does not serve a service.  The secret store is only set up when it is
+// first needed.
+type agentStore struct {
+	socket string
+	scope  string
+	client *http.Client
+
+	mu      sync.Mutex
+	backend store.Store
+}
+
+func newAgentStore() *agentStore {
+	if _, disabled := os.LookupEnv("CHAMBER_NO_AGENT"); disabled {
+		return &agentStore{}
+	}
+	return connectAgent()
+}
+
+// connectAgent returns an agentStore talking to the agent of the current
+// scope, if one is running, even if CHAMBER_NO_AGENT is set
+func connectAgent() *agentStore {
+	s := &agentStore{}
+	if socket := agentSocket(); socket != "" {
+		if info, err := os.Stat(socket); err == nil && info.Mode()&os.ModeSocket != 0 {
+			s.socket = socket
+			s.scope = agentScope()
+			s.client = &http.Client{
+				Timeout: 5 * time.Second,
+				Transport: &http.Transport{
+					DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+						var d net.Dialer
+						return d.DialContext(ctx, "unix", socket)
+					},
+				},
+			}
+		}
+	}
+	return s
+}
+
+func (s *agentStore) Read(id store.SecretId, version int) (store.Secret, error) {
+	if version == -1 {
+		var secret servedSecret
+		found, err := s.get("/v1/services/"+id.Service+"/"+id.Key, &secret)
+		if err == nil {
+			if !found {
+				return store.Secret{}, store.ErrSecretNotFound
+			}
+			return store.Secret{
+				Value: &secret.Value,
+				Meta: store.SecretMetadata{
+					Created:     secret.Modified,
+					CreatedBy:   secret.ModifiedBy,
+					Version:     secret.Version,
+					Key:         secretName(id.Service, id.Key),
+					Description: secret.Description,
+					Tags:        secret.Tags,
+					Type:        secret.Type,
+				},
+			}, nil
+		}
+	}
+	return s.secretStore().Read(id, version)
+}
+
+func (s *agentStore) ListRaw(service string) ([]store.RawSecret, error) {
+	secrets := []agentSecret{}
+	found, err := s.get("/v1/raw/"+service, &secrets)
+	if err != nil {
+		logger.Log(store.LogInfo, "reading from the secret store instead of the agent", map[string]interface{}{
+			"service": service,
+			"reason":  err.Error(),
+		})
+		return s.secretStore().ListRaw(service)
+	}
+
+	if !found {
+		// Services that are not served are read from the secret store
+		return s.secretStore().ListRaw(service)
+	}
+	sort.Slice(secrets, func(i, j int) bool { return secrets[i].Key < secrets[j].Key })
+	rawSecrets := []store.RawSecret{}
+	for _, secret := range secrets {
+		rawSecrets = append(rawSecrets, store.RawSecret{
+			Key:   secretName(service, secret.Key),
+			Value: secret.Value,
+			Type:  secret.Type,
+		})
+	}
+	return rawSecrets, nil
+}
+
+// get requests path from the agent and decodes the response into v.  found
+// is false if the agent served a 404.  An error is returned if the agent is
+// not running, reads secrets of another scope or does not serve the service.
+func (s *agentStore) get(path string, v interface{}) (found bool, err error) {
+	if s.client == nil {
+		return false, errors.New("no agent is running")
+	}
+
+	resp, err := s.client.Get("http://agent" + path)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	if scope := resp.Header.Get(agentScopeHeader); scope != s.scope {
+		return false, errors.New("the agent reads secrets with other AWS credentials, region or config profile")
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, json.NewDecoder(resp.Body).Decode(v)
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		return false, errors.Errorf("agent responded with %s", resp.Status)
+	}
+}
+
+// refresh makes the agent read the secrets of service again, if it serves
+// them
+func (s *agentStore) refresh(service string) {
+	if s.client == nil {
+		return
+	}
+
+	resp, err := s.client.Post("http://agent/v1/refresh/"+service, "", nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "warning: failed to refresh the agent on %s, it may serve stale secrets of %s: %s\n", s.socket, service, err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusNotFound {
+		fmt.Fprintf(os.Stderr, "warning: failed to refresh the agent on %s, it may serve stale secrets of %s: %s\n", s.socket, service, resp.Status)
+	}
+}
+
+// agentRefreshingStore makes a running agent read a service again after its
+// secrets were changed, so that reads through the agent are not stale
+type agentRefreshingStore struct {
+	store.Store
+	agent *agentStore
+}
+
+func (s *agentRefreshingStore) Write(id store.SecretId, value string, opts store.WriteOptions) error {
+	if err := s.Store.Write(id, value, opts); err != nil {
+		return err
+	}
+	s.agent.refresh(id.Service)
+	return nil
+}
+
+func (s *agentRefreshingStore) Delete(id store.SecretId) error {
+	if err := s.Store.Delete(id); err != nil {
+		return err
+	}
+	s.agent.refresh(id.Service)
+	return nil
+}
+
+func (s *agentRefreshingStore) SoftDelete(id store.SecretId) error {
+	if err := s.Store.SoftDelete(id); err != nil {
+		return err
+	}
+	s.agent.refresh(id.Service)
+	return nil
+}
+
+func (s *agentRefreshingStore) Undelete(id store.SecretId) error {
+	if err := s.Store.Undelete(id); err != nil {
+		return err
+	}
+	s.agent.refresh(id.Service)
+	return nil
+}
+
+func (s *agentStore) secretStore() store.Store {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.backend == nil {
+		s.backend = getSecretStore()
+	}
+	return s.backend
+}
+
+// secretName returns the name of a secret in the format understood by key
+func secretName(service, k string) string {
+	if noPaths() {
+		return service + "." + k
+	}
+	return "/" + service + "/" + k
+}
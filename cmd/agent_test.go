@@ -0,0 +1,193 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/segmentio/chamber/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAgentStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "chamber-agent")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	socket := filepath.Join(dir, "agent.sock")
+	os.Setenv("CHAMBER_AGENT_SOCKET", socket)
+	defer os.Unsetenv("CHAMBER_AGENT_SOCKET")
+
+	backend := &fakeStore{secrets: map[store.SecretId]string{
+		{Service: "billing", Key: "db_password"}: "hunter2",
+		{Service: "other", Key: "api_key"}:       "from the store",
+	}}
+	listStore := &fakeListStore{fakeStore: backend, types: map[store.SecretId]string{
+		{Service: "billing", Key: "db_password"}: store.TypeSecureString,
+	}}
+	cache := &agentCache{services: map[string][]store.RawSecret{}}
+	assert.Nil(t, cache.refresh(listStore, []string{"billing"}))
+	assert.False(t, listStore.listed)
+
+	listener, err := listen("unix:" + socket)
+	assert.Nil(t, err)
+	server := &http.Server{Handler: newAgentHandler(cache, listStore, []string{"billing"}, agentScope())}
+	go server.Serve(listener)
+	defer server.Close()
+
+	// Changes made behind the agent's back are not seen through it until
+	// its next refresh
+	backend.secrets[store.SecretId{Service: "billing", Key: "db_password"}] = "changed"
+
+	agentStore := newAgentStore()
+	agentStore.backend = backend
+
+	t.Run("Secrets of served services should be read from the agent", func(t *testing.T) {
+		secret, err := agentStore.Read(store.SecretId{Service: "billing", Key: "db_password"}, -1)
+		assert.Nil(t, err)
+		assert.Equal(t, "hunter2", *secret.Value)
+
+		rawSecrets, err := agentStore.ListRaw("billing")
+		assert.Nil(t, err)
+		assert.Equal(t, []store.RawSecret{{Key: "/billing/db_password", Value: "hunter2", Type: store.TypeSecureString}}, rawSecrets)
+
+		_, err = agentStore.Read(store.SecretId{Service: "billing", Key: "nope"}, -1)
+		assert.Equal(t, store.ErrSecretNotFound, err)
+	})
+
+	t.Run("Other services should be read from the store", func(t *testing.T) {
+		secret, err := agentStore.Read(store.SecretId{Service: "other", Key: "api_key"}, -1)
+		assert.Nil(t, err)
+		assert.Equal(t, "from the store", *secret.Value)
+
+		rawSecrets, err := agentStore.ListRaw("other")
+		assert.Nil(t, err)
+		assert.Equal(t, []store.RawSecret{{Key: "/other/api_key", Value: "from the store"}}, rawSecrets)
+	})
+
+	t.Run("Without an agent secrets should be read from the store", func(t *testing.T) {
+		os.Setenv("CHAMBER_NO_AGENT", "1")
+		defer os.Unsetenv("CHAMBER_NO_AGENT")
+		agentStore := newAgentStore()
+		agentStore.backend = backend
+
+		secret, err := agentStore.Read(store.SecretId{Service: "billing", Key: "db_password"}, -1)
+		assert.Nil(t, err)
+		assert.Equal(t, "changed", *secret.Value)
+	})
+
+	t.Run("An agent of another scope should be bypassed", func(t *testing.T) {
+		for _, setting := range []struct {
+			variable *string
+			value    string
+		}{
+			{&awsProfile, "production"},
+			{&roleARN, "arn:aws:iam::123456789012:role/admin"},
+			{&configProfile, "staging"},
+		} {
+			previous := *setting.variable
+			*setting.variable = setting.value
+			agentStore := newAgentStore()
+			agentStore.backend = backend
+
+			secret, err := agentStore.Read(store.SecretId{Service: "billing", Key: "db_password"}, -1)
+			assert.Nil(t, err)
+			assert.Equal(t, "changed", *secret.Value, setting.value)
+			*setting.variable = previous
+		}
+
+		for _, variable := range []string{"CHAMBER_AWS_REGION", "CHAMBER_NO_PATHS", "AWS_PROFILE"} {
+			os.Setenv(variable, "1")
+			agentStore := newAgentStore()
+			agentStore.backend = backend
+
+			rawSecrets, err := agentStore.ListRaw("billing")
+			assert.Nil(t, err)
+			assert.Equal(t, "changed", rawSecrets[0].Value, variable)
+			os.Unsetenv(variable)
+		}
+	})
+
+	t.Run("Changes should make the agent read the service again", func(t *testing.T) {
+		secretStore := &agentRefreshingStore{Store: &fakeWriteStore{listStore}, agent: connectAgent()}
+		assert.Nil(t, secretStore.Write(store.SecretId{Service: "billing", Key: "db_password"}, "written", store.WriteOptions{}))
+
+		secret, err := agentStore.Read(store.SecretId{Service: "billing", Key: "db_password"}, -1)
+		assert.Nil(t, err)
+		assert.Equal(t, "written", *secret.Value)
+		assert.False(t, listStore.listed)
+	})
+}
+
+func TestAgentSocket(t *testing.T) {
+	os.Unsetenv("CHAMBER_AGENT_SOCKET")
+	defer os.Setenv("HOME", os.Getenv("HOME"))
+	os.Setenv("HOME", "/home/alice")
+
+	socket := agentSocket()
+	assert.Regexp(t, `^/home/alice/\.chamber/agent-[0-9a-f]{16}\.sock$`, socket)
+
+	os.Setenv("CHAMBER_AWS_REGION", "eu-west-1")
+	defer os.Unsetenv("CHAMBER_AWS_REGION")
+	assert.NotEqual(t, socket, agentSocket())
+
+	os.Setenv("CHAMBER_AGENT_SOCKET", "/tmp/agent.sock")
+	defer os.Unsetenv("CHAMBER_AGENT_SOCKET")
+	assert.Equal(t, "/tmp/agent.sock", agentSocket())
+}
+
+// fakeListStore lists the secrets of a fakeStore along with their types,
+// recording whether the slower List was used
+type fakeListStore struct {
+	*fakeStore
+	types  map[store.SecretId]string
+	listed bool
+}
+
+func (s *fakeListStore) List(service string, includeValues bool, includeTags bool) ([]store.Secret, error) {
+	s.listed = true
+	secrets := []store.Secret{}
+	for id := range s.secrets {
+		if id.Service == service {
+			secret, _ := s.Read(id, -1)
+			secrets = append(secrets, secret)
+		}
+	}
+	return secrets, nil
+}
+
+func (s *fakeListStore) ListRaw(service string) ([]store.RawSecret, error) {
+	rawSecrets := []store.RawSecret{}
+	for id, value := range s.secrets {
+		if id.Service == service {
+			rawSecrets = append(rawSecrets, store.RawSecret{
+				Key:   "/" + id.Service + "/" + id.Key,
+				Value: value,
+				Type:  s.types[id],
+			})
+		}
+	}
+	return rawSecrets, nil
+}
+
+// fakeWriteStore implements Write on top of a fakeListStore
+type fakeWriteStore struct {
+	*fakeListStore
+}
+
+func (s *fakeWriteStore) Write(id store.SecretId, value string, opts store.WriteOptions) error {
+	s.secrets[id] = value
+	return nil
+}
+
+func TestAgentRefreshInterval(t *testing.T) {
+	defer func() { agentRefresh = time.Minute }()
+
+	for _, refresh := range []time.Duration{0, -time.Second} {
+		agentRefresh = refresh
+		assert.NotNil(t, agent(agentCmd, []string{"billing"}), refresh.String())
+	}
+}
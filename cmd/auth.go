@@ -0,0 +1,71 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/segmentio/chamber/store"
+)
+
+var (
+	awsProfile      string
+	roleARN         string
+	externalID      string
+	mfaSerial       string
+	sessionDuration time.Duration
+
+	mfaPrompt sync.Mutex
+)
+
+func init() {
+	RootCmd.PersistentFlags().StringVar(&awsProfile, "profile", "", "AWS shared config profile to use (default is $AWS_PROFILE)")
+	RootCmd.PersistentFlags().StringVar(&roleARN, "role-arn", "", "ARN of an IAM role to assume")
+	RootCmd.PersistentFlags().StringVar(&externalID, "external-id", "", "External ID required to assume the role")
+	RootCmd.PersistentFlags().StringVar(&mfaSerial, "mfa-serial", "", "Serial number or ARN of the MFA device to authenticate with")
+	RootCmd.PersistentFlags().DurationVar(&sessionDuration, "session-duration", 0, "How long temporary credentials last (default is the STS default)")
+}
+
+// authOptions returns the credentials and region selected by flags and the
+// config file.  Temporary credentials are cached in ~/.chamber/cache.
+func authOptions() store.AuthOptions {
+	auth := store.AuthOptions{
+		Region:          configRegion(),
+		Profile:         awsProfile,
+		RoleARN:         roleARN,
+		ExternalID:      externalID,
+		MFASerial:       mfaSerial,
+		SessionDuration: sessionDuration,
+		TokenProvider:   mfaCodeReader(mfaSerial),
+	}
+	if home := os.Getenv("HOME"); home != "" {
+		auth.CacheDir = filepath.Join(home, ".chamber", "cache")
+	}
+	return auth
+}
+
+// mfaCodeReader returns a function prompting for the current code of the MFA
+// device serial on stderr and reading it from stdin.  Stores of several
+// accounts prompt one at a time.
+func mfaCodeReader(serial string) func() (string, error) {
+	return func() (string, error) {
+		mfaPrompt.Lock()
+		defer mfaPrompt.Unlock()
+
+		if serial != "" {
+			fmt.Fprintf(os.Stderr, "MFA code for %s: ", serial)
+		} else {
+			fmt.Fprint(os.Stderr, "MFA code: ")
+		}
+		code, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil {
+			return "", errors.Wrap(err, "Failed to read MFA code")
+		}
+		return strings.TrimSpace(code), nil
+	}
+}
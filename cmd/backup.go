@@ -0,0 +1,299 @@
+package cmd
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/segmentio/chamber/store"
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+const (
+	// backupFormatVersion is the version of the backup file format
+	backupFormatVersion = 1
+
+	// backupKDFIterations is the number of PBKDF2-SHA256 iterations used to
+	// derive the backup encryption key from the passphrase
+	backupKDFIterations = 600000
+
+	// minBackupKDFIterations and maxBackupKDFIterations bound the iterations
+	// accepted from a backup file, so that a corrupted or crafted file can
+	// neither weaken the key derivation nor make it run for hours
+	minBackupKDFIterations = 100000
+	maxBackupKDFIterations = 10000000
+)
+
+var (
+	backupOutput   string
+	passphraseFile string
+
+	// backupCmd represents the backup command
+	backupCmd = &cobra.Command{
+		Use:   "backup <service...> --out <file>",
+		Short: "Back up every version and the metadata of the secrets of services to an encrypted file",
+		Long: `Back up every version and the metadata of the secrets of services to an encrypted file.
+
+The file is encrypted with AES-256-GCM using a key derived from a passphrase
+with PBKDF2-SHA256.  The passphrase is read from --passphrase-file or
+$CHAMBER_BACKUP_PASSPHRASE; encrypting to age or PGP recipients is not
+supported.`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: runBackup,
+	}
+)
+
+// encryptedBackup is the envelope written to the backup file
+type encryptedBackup struct {
+	Version    int    `json:"version"`
+	KDF        string `json:"kdf"`
+	Iterations int    `json:"iterations"`
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// backup is the plaintext content of a backup
+type backup struct {
+	Created  time.Time                 `json:"created"`
+	Services map[string][]backupSecret `json:"services"`
+}
+
+type backupSecret struct {
+	Key         string            `json:"key"`
+	Description string            `json:"description,omitempty"`
+	Tags        map[string]string `json:"tags,omitempty"`
+	Versions    []backupVersion   `json:"versions"`
+}
+
+type backupVersion struct {
+	Version   int       `json:"version"`
+	Value     string    `json:"value"`
+	Created   time.Time `json:"created"`
+	CreatedBy string    `json:"created_by"`
+	// Type and KMSKey are the parameter type and KMS key the version was
+	// stored with, which backups taken by older releases do not record
+	Type   string `json:"type,omitempty"`
+	KMSKey string `json:"kms_key,omitempty"`
+}
+
+func init() {
+	backupCmd.Flags().StringVarP(&backupOutput, "out", "o", "", "File to write the backup to")
+	backupCmd.Flags().StringVar(&passphraseFile, "passphrase-file", "", "File containing the passphrase to encrypt the backup with (default is $CHAMBER_BACKUP_PASSPHRASE)")
+	RootCmd.AddCommand(backupCmd)
+}
+
+func runBackup(cmd *cobra.Command, args []string) error {
+	if backupOutput == "" {
+		return errors.New("an output file must be given with --out")
+	}
+
+	passphrase, err := readPassphrase()
+	if err != nil {
+		return err
+	}
+
+	services := make([]string, len(args))
+	for i, service := range args {
+		services[i] = strings.ToLower(service)
+		if err := validateService(services[i]); err != nil {
+			return errors.Wrapf(err, "Failed to validate service %s", services[i])
+		}
+	}
+
+	b, count, err := backupServices(getSecretStore(), services)
+	if err != nil {
+		return err
+	}
+	if err := writeBackup(b, passphrase, backupOutput); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stdout, "Successfully backed up %d secrets from %d services\n", count, len(b.Services))
+	return nil
+}
+
+// backupServices reads every version and the metadata of the secrets of
+// services, and returns them along with the number of secrets
+func backupServices(secretStore store.Store, services []string) (backup, int, error) {
+	b := backup{
+		Created:  time.Now().UTC(),
+		Services: map[string][]backupSecret{},
+	}
+	count := 0
+	for _, service := range services {
+		secrets, err := secretStore.List(service, false, true)
+		if err != nil {
+			return backup{}, 0, errors.Wrapf(err, "Failed to list store contents for service %s", service)
+		}
+		if err := checkTagsRead(secrets); err != nil {
+			return backup{}, 0, errors.Wrapf(err, "Failed to back up service %s", service)
+		}
+
+		backupSecrets := []backupSecret{}
+		for _, secret := range secrets {
+			secretId := store.SecretId{
+				Service: service,
+				Key:     key(secret.Meta.Key),
+			}
+			versions, err := readAllVersions(secretStore, secretId)
+			if err != nil {
+				return backup{}, 0, errors.Wrapf(err, "Failed to read versions of %s/%s", secretId.Service, secretId.Key)
+			}
+			backupSecrets = append(backupSecrets, backupSecret{
+				Key:         secretId.Key,
+				Description: secret.Meta.Description,
+				Tags:        secret.Meta.Tags,
+				Versions:    versions,
+			})
+			count++
+		}
+		b.Services[service] = backupSecrets
+	}
+	return b, count, nil
+}
+
+// writeBackup encrypts b with passphrase and writes it to file, which must
+// not exist, and is only readable by the current user
+func writeBackup(b backup, passphrase []byte, file string) error {
+	plaintext, err := json.Marshal(b)
+	if err != nil {
+		return errors.Wrap(err, "Failed to encode backup")
+	}
+
+	encrypted, err := encryptBackup(plaintext, passphrase)
+	if err != nil {
+		return errors.Wrap(err, "Failed to encrypt backup")
+	}
+
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return errors.Wrap(err, "Failed to open output file for writing")
+	}
+	defer f.Close()
+
+	if err := json.NewEncoder(f).Encode(encrypted); err != nil {
+		return errors.Wrap(err, "Failed to write backup")
+	}
+	if err := f.Sync(); err != nil {
+		return errors.Wrap(err, "Failed to write backup")
+	}
+	return nil
+}
+
+// readAllVersions reads every version of a secret, oldest first
+func readAllVersions(secretStore store.Store, secretId store.SecretId) ([]backupVersion, error) {
+	secrets, err := secretStore.Versions(secretId)
+	if err != nil {
+		return nil, err
+	}
+
+	versions := []backupVersion{}
+	seen := map[int]bool{}
+	for _, secret := range secrets {
+		if seen[secret.Meta.Version] {
+			continue
+		}
+		seen[secret.Meta.Version] = true
+
+		versions = append(versions, backupVersion{
+			Version:   secret.Meta.Version,
+			Value:     *secret.Value,
+			Created:   secret.Meta.Created,
+			CreatedBy: secret.Meta.CreatedBy,
+			Type:      secret.Meta.Type,
+			KMSKey:    secret.Meta.KMSKey,
+		})
+	}
+	return versions, nil
+}
+
+// readPassphrase reads the backup passphrase from --passphrase-file or the
+// CHAMBER_BACKUP_PASSPHRASE environment variable
+func readPassphrase() ([]byte, error) {
+	var passphrase []byte
+	if passphraseFile != "" {
+		contents, err := ioutil.ReadFile(passphraseFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "Failed to read passphrase file")
+		}
+		passphrase = []byte(strings.TrimRight(string(contents), "\r\n"))
+	} else {
+		passphrase = []byte(os.Getenv("CHAMBER_BACKUP_PASSPHRASE"))
+	}
+
+	if len(passphrase) == 0 {
+		return nil, errors.New("a passphrase must be given with --passphrase-file or CHAMBER_BACKUP_PASSPHRASE")
+	}
+	return passphrase, nil
+}
+
+// encryptBackup encrypts plaintext with AES-256-GCM, using a key derived
+// from passphrase with PBKDF2-SHA256
+func encryptBackup(plaintext, passphrase []byte) (encryptedBackup, error) {
+	salt := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return encryptedBackup{}, err
+	}
+
+	gcm, err := backupCipher(passphrase, salt, backupKDFIterations)
+	if err != nil {
+		return encryptedBackup{}, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return encryptedBackup{}, err
+	}
+
+	return encryptedBackup{
+		Version:    backupFormatVersion,
+		KDF:        "pbkdf2-sha256",
+		Iterations: backupKDFIterations,
+		Salt:       salt,
+		Nonce:      nonce,
+		Ciphertext: gcm.Seal(nil, nonce, plaintext, nil),
+	}, nil
+}
+
+// decryptBackup is the inverse of encryptBackup
+func decryptBackup(encrypted encryptedBackup, passphrase []byte) ([]byte, error) {
+	if encrypted.Version != backupFormatVersion || encrypted.KDF != "pbkdf2-sha256" {
+		return nil, fmt.Errorf("unsupported backup format version %d", encrypted.Version)
+	}
+	if encrypted.Iterations < minBackupKDFIterations || encrypted.Iterations > maxBackupKDFIterations {
+		return nil, fmt.Errorf("invalid number of key derivation iterations %d", encrypted.Iterations)
+	}
+
+	gcm, err := backupCipher(passphrase, encrypted.Salt, encrypted.Iterations)
+	if err != nil {
+		return nil, err
+	}
+	if len(encrypted.Nonce) != gcm.NonceSize() {
+		return nil, errors.New("invalid nonce")
+	}
+
+	plaintext, err := gcm.Open(nil, encrypted.Nonce, encrypted.Ciphertext, nil)
+	if err != nil {
+		return nil, errors.New("wrong passphrase or corrupted backup")
+	}
+	return plaintext, nil
+}
+
+func backupCipher(passphrase, salt []byte, iterations int) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(pbkdf2.Key(passphrase, salt, iterations, 32, sha256.New))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
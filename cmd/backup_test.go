@@ -0,0 +1,136 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/segmentio/chamber/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackupEncryption(t *testing.T) {
+	plaintext := []byte(`{"services":{"api":[]}}`)
+
+	encrypted, err := encryptBackup(plaintext, []byte("correct horse"))
+	assert.Nil(t, err)
+	assert.NotContains(t, string(encrypted.Ciphertext), "services")
+
+	t.Run("Decrypting with the right passphrase should return the plaintext", func(t *testing.T) {
+		decrypted, err := decryptBackup(encrypted, []byte("correct horse"))
+		assert.Nil(t, err)
+		assert.Equal(t, plaintext, decrypted)
+	})
+
+	t.Run("Decrypting with the wrong passphrase should fail", func(t *testing.T) {
+		_, err := decryptBackup(encrypted, []byte("battery staple"))
+		assert.NotNil(t, err)
+	})
+
+	t.Run("Decrypting with too few or too many iterations should fail", func(t *testing.T) {
+		for _, iterations := range []int{-1, 0, 1, minBackupKDFIterations - 1, maxBackupKDFIterations + 1} {
+			tampered := encrypted
+			tampered.Iterations = iterations
+			_, err := decryptBackup(tampered, []byte("correct horse"))
+			assert.NotNil(t, err, "%d iterations", iterations)
+		}
+	})
+}
+
+func TestValidateBackup(t *testing.T) {
+	valid := backup{Services: map[string][]backupSecret{
+		"api": {{Key: "db_password"}},
+	}}
+	assert.Nil(t, validateBackup(valid))
+
+	for name, b := range map[string]backup{
+		"deleted service": {Services: map[string][]backupSecret{store.DeletedService: {{Key: "db_password"}}}},
+		"nested service":  {Services: map[string][]backupSecret{"api/../billing": {{Key: "db_password"}}}},
+		"empty service":   {Services: map[string][]backupSecret{"": {{Key: "db_password"}}}},
+		"invalid key":     {Services: map[string][]backupSecret{"api": {{Key: "db_password"}, {Key: "db/password"}}}},
+		"empty key":       {Services: map[string][]backupSecret{"api": {{Key: ""}}}},
+	} {
+		assert.NotNil(t, validateBackup(b), name)
+	}
+}
+
+func TestRestoreVersions(t *testing.T) {
+	defer func() { config = configSettings{} }()
+	config.KMSKeys = []kmsKeyMapping{{Pattern: "pci-*", Key: "pci_key"}}
+
+	for _, test := range []struct {
+		name        string
+		service     string
+		kmsKeyAlias string
+		versions    []backupVersion
+		existing    store.Secret
+		expected    []store.WriteOptions
+	}{
+		{
+			name:    "String versions should be restored unencrypted under a mapped key",
+			service: "pci-api",
+			versions: []backupVersion{
+				{Version: 1, Value: "a", Type: store.TypeString},
+				{Version: 2, Value: "b", Type: store.TypeString},
+			},
+			expected: []store.WriteOptions{
+				{Type: store.TypeString},
+				{Type: store.TypeString, Description: "desc"},
+			},
+		},
+		{
+			name:     "SecureString versions should be restored with their key alias",
+			service:  "api",
+			versions: []backupVersion{{Version: 1, Value: "a", Type: store.TypeSecureString, KMSKey: "alias/old_key"}},
+			expected: []store.WriteOptions{{Type: store.TypeSecureString, KMSKey: "alias/old_key", Description: "desc"}},
+		},
+		{
+			name:        "The default key should take precedence over the backed up key",
+			service:     "api",
+			kmsKeyAlias: "project_key",
+			versions:    []backupVersion{{Version: 1, Value: "a", Type: store.TypeSecureString, KMSKey: "alias/old_key"}},
+			expected:    []store.WriteOptions{{Type: store.TypeSecureString, Description: "desc"}},
+		},
+		{
+			name:     "Backed up key ARNs should not be reused",
+			service:  "api",
+			versions: []backupVersion{{Version: 1, Value: "a", Type: store.TypeSecureString, KMSKey: "arn:aws:kms:us-east-1:111122223333:key/1234abcd-12ab-34cd-56ef-1234567890ab"}},
+			expected: []store.WriteOptions{{Type: store.TypeSecureString, Description: "desc"}},
+		},
+		{
+			name:     "The key mapped to the service should take precedence over the backed up key",
+			service:  "pci-api",
+			versions: []backupVersion{{Version: 1, Value: "a", Type: store.TypeSecureString, KMSKey: "alias/old_key"}},
+			expected: []store.WriteOptions{{Type: store.TypeSecureString, KMSKey: "pci_key", Description: "desc"}},
+		},
+		{
+			name:     "Versions of older backups should be restored as new SecureString secrets",
+			service:  "pci-api",
+			versions: []backupVersion{{Version: 1, Value: "a"}},
+			expected: []store.WriteOptions{{KMSKey: "pci_key", Description: "desc"}},
+		},
+		{
+			name:     "Versions of older backups should keep the type of existing secrets",
+			service:  "pci-api",
+			versions: []backupVersion{{Version: 1, Value: "a"}},
+			existing: newTypedSecret(store.SecretId{Service: "pci-api", Key: "host"}, "b", store.TypeString, ""),
+			expected: []store.WriteOptions{{Description: "desc"}},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			config.KMSKeyAlias = test.kmsKeyAlias
+			secretId := store.SecretId{Service: test.service, Key: "host"}
+			secretStore := &fakeTypedStore{secrets: map[store.SecretId]store.Secret{}}
+			if test.existing.Value != nil {
+				secretStore.secrets[secretId] = test.existing
+			}
+
+			secret := backupSecret{Key: "host", Description: "desc", Versions: test.versions}
+			assert.Nil(t, restoreVersions(secretStore, secretId, secret, test.versions, test.existing))
+
+			opts := []store.WriteOptions{}
+			for _, write := range secretStore.writes {
+				opts = append(opts, write.opts)
+			}
+			assert.Equal(t, test.expected, opts)
+		})
+	}
+}
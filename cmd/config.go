@@ -0,0 +1,339 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/segmentio/chamber/store"
+	"github.com/spf13/cobra"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// ConfigFileName is the name of the project config file, which is looked for
+// in the current directory and its parents
+const ConfigFileName = ".chamber.yaml"
+
+// config holds the settings of the project config file, with the selected
+// profile applied.  It is empty if there is no config file.
+var (
+	config        configSettings
+	configProfile string
+
+	// kmsKey is the --kms-key flag of the commands that write secrets
+	kmsKey string
+)
+
+// configFile is the content of a project config file
+type configFile struct {
+	configSettings `yaml:",inline"`
+	Profiles       map[string]configSettings `yaml:"profiles"`
+}
+
+// configSettings are the settings that can be given at the top level of a
+// config file and overridden by profiles
+type configSettings struct {
+	// Services are the default services of exec and export
+	Services    []string `yaml:"services"`
+	Backend     string   `yaml:"backend"`
+	KMSKeyAlias string   `yaml:"kms_key_alias"`
+	// KMSKeys map services to KMS keys, the first matching pattern wins
+	KMSKeys []kmsKeyMapping `yaml:"kms_keys"`
+	Region  string          `yaml:"region"`
+	Retries *int            `yaml:"retries"`
+	// RateLimit is the maximum number of requests per second to SSM
+	RateLimit *float64 `yaml:"rate_limit"`
+	NoPaths   *bool    `yaml:"no_paths"`
+	// Profile, RoleARN, ExternalID, MFASerial and SessionDuration select
+	// the AWS credentials to use
+	Profile         string `yaml:"profile"`
+	RoleARN         string `yaml:"role_arn"`
+	ExternalID      string `yaml:"external_id"`
+	MFASerial       string `yaml:"mfa_serial"`
+	SessionDuration string `yaml:"session_duration"`
+	// Accounts select the credentials and region of the accounts services
+	// can be read from, as <account>:<service>
+	Accounts map[string]accountSettings `yaml:"accounts"`
+	Env      envNaming                  `yaml:"env"`
+}
+
+// kmsKeyMapping selects the KMS key of the services whose name matches a
+// shell pattern
+type kmsKeyMapping struct {
+	Pattern string `yaml:"pattern"`
+	Key     string `yaml:"key"`
+}
+
+// envNaming are the rules used to name the environment variables secrets are
+// exposed as
+type envNaming struct {
+	// Prefix is prepended to every variable name
+	Prefix string `yaml:"prefix"`
+	// Case is the case of variable names: upper (the default), lower or
+	// preserve
+	Case string `yaml:"case"`
+}
+
+func init() {
+	RootCmd.PersistentFlags().StringVar(&configProfile, "config-profile", "", "Profile of the "+ConfigFileName+" config file to use (default is $CHAMBER_CONFIG_PROFILE)")
+}
+
+// loadConfig loads the project config file, if there is one, and applies
+// its settings that are not overridden by flags or environment variables
+func loadConfig(cmd *cobra.Command, args []string) error {
+	profile := configProfile
+	if profile == "" {
+		profile = os.Getenv("CHAMBER_CONFIG_PROFILE")
+	}
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		return errors.Wrap(err, "Failed to get working directory")
+	}
+	file := findConfigFile(cwd)
+	if file == "" {
+		if profile != "" {
+			return errors.Errorf("Config profile %s was given, but no %s file was found", profile, ConfigFileName)
+		}
+		return nil
+	}
+
+	config, err = readConfigFile(file, profile)
+	if err != nil {
+		return errors.Wrapf(err, "Failed to read config file %s", file)
+	}
+	logger.Log(store.LogInfo, "loaded config file", map[string]interface{}{
+		"file":    file,
+		"profile": profile,
+	})
+	return applyConfig(cmd, config)
+}
+
+// findConfigFile looks for the config file in dir and its parents, stopping
+// at the root of the git repository or the home directory dir is in, so that
+// the config file of an unrelated project above it is not picked up
+func findConfigFile(dir string) string {
+	home := os.Getenv("HOME")
+	if home != "" {
+		home = filepath.Clean(home)
+	}
+	for {
+		file := filepath.Join(dir, ConfigFileName)
+		if info, err := os.Stat(file); err == nil && !info.IsDir() {
+			return file
+		}
+		if dir == home {
+			return ""
+		}
+		if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+			return ""
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// readConfigFile reads a config file, applying the settings of profile over
+// the top level ones
+func readConfigFile(file, profile string) (configSettings, error) {
+	contents, err := ioutil.ReadFile(file)
+	if err != nil {
+		return configSettings{}, err
+	}
+
+	var parsed configFile
+	if err := yaml.UnmarshalStrict(contents, &parsed); err != nil {
+		return configSettings{}, err
+	}
+
+	settings := parsed.configSettings
+	if profile != "" {
+		overrides, ok := parsed.Profiles[profile]
+		if !ok {
+			return configSettings{}, errors.Errorf("profile %s is not defined", profile)
+		}
+		settings = settings.merge(overrides)
+	}
+
+	switch settings.Backend {
+	case "", "ssm":
+	default:
+		return configSettings{}, errors.Errorf("unsupported backend %s", settings.Backend)
+	}
+	switch settings.Env.Case {
+	case "", "upper", "lower", "preserve":
+	default:
+		return configSettings{}, errors.Errorf("unsupported env case %s", settings.Env.Case)
+	}
+	if _, err := parseServiceRefs(settings.Services); err != nil {
+		return configSettings{}, err
+	}
+	for _, mapping := range settings.KMSKeys {
+		if _, err := path.Match(mapping.Pattern, ""); err != nil || mapping.Key == "" {
+			return configSettings{}, errors.Errorf("invalid kms_keys entry for pattern %s", mapping.Pattern)
+		}
+	}
+	return settings, nil
+}
+
+// merge returns s with the settings that are set in overrides replaced
+func (s configSettings) merge(overrides configSettings) configSettings {
+	if overrides.Services != nil {
+		s.Services = overrides.Services
+	}
+	if overrides.Backend != "" {
+		s.Backend = overrides.Backend
+	}
+	if overrides.KMSKeyAlias != "" {
+		s.KMSKeyAlias = overrides.KMSKeyAlias
+	}
+	if overrides.KMSKeys != nil {
+		s.KMSKeys = overrides.KMSKeys
+	}
+	if overrides.Region != "" {
+		s.Region = overrides.Region
+	}
+	if overrides.Retries != nil {
+		s.Retries = overrides.Retries
+	}
+	if overrides.RateLimit != nil {
+		s.RateLimit = overrides.RateLimit
+	}
+	if overrides.NoPaths != nil {
+		s.NoPaths = overrides.NoPaths
+	}
+	if overrides.Profile != "" {
+		s.Profile = overrides.Profile
+	}
+	if overrides.RoleARN != "" {
+		s.RoleARN = overrides.RoleARN
+	}
+	if overrides.ExternalID != "" {
+		s.ExternalID = overrides.ExternalID
+	}
+	if overrides.MFASerial != "" {
+		s.MFASerial = overrides.MFASerial
+	}
+	if overrides.SessionDuration != "" {
+		s.SessionDuration = overrides.SessionDuration
+	}
+	if overrides.Accounts != nil {
+		s.Accounts = overrides.Accounts
+	}
+	if overrides.Env.Prefix != "" {
+		s.Env.Prefix = overrides.Env.Prefix
+	}
+	if overrides.Env.Case != "" {
+		s.Env.Case = overrides.Env.Case
+	}
+	return s
+}
+
+// applyConfig applies the settings of the config file to the flags that
+// were not given.  The settings that environment variables take precedence
+// over are resolved when they are used, rather than exported, so that the
+// commands run by exec don't inherit them.
+func applyConfig(cmd *cobra.Command, settings configSettings) error {
+	if settings.Retries != nil && !cmd.Flags().Changed("retries") {
+		numRetries = *settings.Retries
+	}
+	if settings.RateLimit != nil && !cmd.Flags().Changed("rate-limit") {
+		rateLimit = *settings.RateLimit
+	}
+	profile := settings.Profile
+	if _, ok := os.LookupEnv("AWS_PROFILE"); ok {
+		profile = ""
+	}
+	for flag, setting := range map[string]struct {
+		value  *string
+		config string
+	}{
+		"profile":     {&awsProfile, profile},
+		"role-arn":    {&roleARN, settings.RoleARN},
+		"external-id": {&externalID, settings.ExternalID},
+		"mfa-serial":  {&mfaSerial, settings.MFASerial},
+	} {
+		if setting.config != "" && !cmd.Flags().Changed(flag) {
+			*setting.value = setting.config
+		}
+	}
+	if settings.SessionDuration != "" && !cmd.Flags().Changed("session-duration") {
+		duration, err := time.ParseDuration(settings.SessionDuration)
+		if err != nil {
+			return errors.Wrap(err, "Failed to parse session_duration")
+		}
+		sessionDuration = duration
+	}
+	return nil
+}
+
+// configRegion returns the region of the config file, unless it is
+// overridden by CHAMBER_AWS_REGION.  The store reads CHAMBER_AWS_REGION
+// itself, so that an empty region is returned then.
+func configRegion() string {
+	if _, ok := os.LookupEnv("CHAMBER_AWS_REGION"); ok {
+		return ""
+	}
+	return config.Region
+}
+
+// noPaths returns whether secrets are named service.key instead of by path,
+// as selected by CHAMBER_NO_PATHS or the config file
+func noPaths() bool {
+	if _, ok := os.LookupEnv("CHAMBER_NO_PATHS"); ok {
+		return true
+	}
+	return config.NoPaths != nil && *config.NoPaths
+}
+
+// servicesOrDefault returns services, or the default services of the config
+// file if none were given
+func servicesOrDefault(services []string) ([]string, error) {
+	if len(services) > 0 {
+		return services, nil
+	}
+	if len(config.Services) > 0 {
+		return config.Services, nil
+	}
+	return nil, errors.Errorf("at least one service must be specified, or default services set in %s", ConfigFileName)
+}
+
+// kmsKeyFor returns the KMS key secrets of service are written with: the
+// --kms-key flag if given, or the key mapped to the service in the config
+// file.  An empty key means the store's default key.
+func kmsKeyFor(service string) string {
+	if kmsKey != "" {
+		return kmsKey
+	}
+	for _, mapping := range config.KMSKeys {
+		if matched, _ := path.Match(mapping.Pattern, service); matched {
+			return mapping.Key
+		}
+	}
+	return ""
+}
+
+// defaultKMSKeyConfigured returns whether the store's default KMS key is
+// chosen with CHAMBER_KMS_KEY_ALIAS or the config file
+func defaultKMSKeyConfigured() bool {
+	if _, ok := os.LookupEnv("CHAMBER_KMS_KEY_ALIAS"); ok {
+		return true
+	}
+	return config.KMSKeyAlias != ""
+}
+
+// kmsKeyForType returns the KMS key a secret of service stored as paramType
+// is written with.  Only SecureString secrets are encrypted, so there is no
+// key for the other types.
+func kmsKeyForType(service, paramType string) string {
+	if paramType != store.TypeSecureString {
+		return ""
+	}
+	return kmsKeyFor(service)
+}
@@ -0,0 +1,194 @@
+package cmd
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	osexec "os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/segmentio/chamber/store"
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+)
+
+const testConfigFile = `
+services: [api, shared]
+region: us-east-1
+retries: 3
+env:
+  prefix: APP_
+profiles:
+  prod:
+    services: [api-prod]
+    region: us-west-2
+    kms_key_alias: prod_key
+    env:
+      case: preserve
+`
+
+func TestReadConfigFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "chamber-config")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, ConfigFileName)
+	assert.Nil(t, ioutil.WriteFile(file, []byte(testConfigFile), 0644))
+
+	t.Run("The config file should be found from subdirectories", func(t *testing.T) {
+		subdir := filepath.Join(dir, "a", "b")
+		assert.Nil(t, os.MkdirAll(subdir, 0755))
+		assert.Equal(t, file, findConfigFile(subdir))
+	})
+
+	t.Run("The search should stop at the root of a git repository", func(t *testing.T) {
+		repo := filepath.Join(dir, "repo")
+		subdir := filepath.Join(repo, "cmd")
+		assert.Nil(t, os.MkdirAll(filepath.Join(repo, ".git"), 0755))
+		assert.Nil(t, os.MkdirAll(subdir, 0755))
+		defer os.RemoveAll(repo)
+
+		assert.Equal(t, "", findConfigFile(subdir))
+
+		repoFile := filepath.Join(repo, ConfigFileName)
+		assert.Nil(t, ioutil.WriteFile(repoFile, []byte("services: [api]\n"), 0644))
+		assert.Equal(t, repoFile, findConfigFile(subdir))
+	})
+
+	t.Run("The search should stop at the home directory", func(t *testing.T) {
+		home := filepath.Join(dir, "home")
+		subdir := filepath.Join(home, "project")
+		assert.Nil(t, os.MkdirAll(subdir, 0755))
+		defer os.RemoveAll(home)
+
+		defer os.Setenv("HOME", os.Getenv("HOME"))
+		os.Setenv("HOME", home+"/")
+		assert.Equal(t, "", findConfigFile(subdir))
+	})
+
+	t.Run("The loaded config file should be logged", func(t *testing.T) {
+		defer func(previous store.Logger) { logger = previous }(logger)
+		defer func() { config = configSettings{} }()
+		buf := &bytes.Buffer{}
+		logger = &textLogger{w: buf, level: store.LogInfo}
+
+		subdir := filepath.Join(dir, "a")
+		cwd, err := os.Getwd()
+		assert.Nil(t, err)
+		assert.Nil(t, os.Chdir(subdir))
+		defer os.Chdir(cwd)
+
+		assert.Nil(t, loadConfig(&cobra.Command{}, nil))
+		assert.Contains(t, buf.String(), "loaded config file file="+file)
+	})
+
+	t.Run("Top level settings should be used without a profile", func(t *testing.T) {
+		settings, err := readConfigFile(file, "")
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"api", "shared"}, settings.Services)
+		assert.Equal(t, "us-east-1", settings.Region)
+		assert.Equal(t, 3, *settings.Retries)
+		assert.Equal(t, envNaming{Prefix: "APP_"}, settings.Env)
+	})
+
+	t.Run("Profiles should override top level settings", func(t *testing.T) {
+		settings, err := readConfigFile(file, "prod")
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"api-prod"}, settings.Services)
+		assert.Equal(t, "us-west-2", settings.Region)
+		assert.Equal(t, "prod_key", settings.KMSKeyAlias)
+		assert.Equal(t, 3, *settings.Retries)
+		assert.Equal(t, envNaming{Prefix: "APP_", Case: "preserve"}, settings.Env)
+	})
+
+	t.Run("Unknown profiles should be rejected", func(t *testing.T) {
+		_, err := readConfigFile(file, "staging")
+		assert.NotNil(t, err)
+	})
+
+	t.Run("Unknown settings should be rejected", func(t *testing.T) {
+		assert.Nil(t, ioutil.WriteFile(file, []byte("regoin: us-east-1\n"), 0644))
+		_, err := readConfigFile(file, "")
+		assert.NotNil(t, err)
+	})
+}
+
+func TestEnvVarName(t *testing.T) {
+	defer func() { config = configSettings{} }()
+
+	assert.Equal(t, "DB_PASSWORD", envVarName("db-password"))
+
+	config.Env = envNaming{Prefix: "APP_"}
+	assert.Equal(t, "APP_DB_PASSWORD", envVarName("db-password"))
+
+	config.Env = envNaming{Case: "lower"}
+	assert.Equal(t, "db_password", envVarName("DB-Password"))
+
+	config.Env = envNaming{Case: "preserve"}
+	assert.Equal(t, "DB_Password", envVarName("DB-Password"))
+}
+
+func TestKMSKeyFor(t *testing.T) {
+	defer func() { config = configSettings{} }()
+	config.KMSKeys = []kmsKeyMapping{
+		{Pattern: "pci-*", Key: "pci_key"},
+		{Pattern: "billing", Key: "billing_key"},
+	}
+
+	assert.Equal(t, "pci_key", kmsKeyFor("pci-payments"))
+	assert.Equal(t, "billing_key", kmsKeyFor("billing"))
+	assert.Equal(t, "", kmsKeyFor("api"))
+
+	assert.Equal(t, "pci_key", kmsKeyForType("pci-payments", store.TypeSecureString))
+	assert.Equal(t, "", kmsKeyForType("pci-payments", store.TypeString))
+	assert.Equal(t, "", kmsKeyForType("pci-payments", store.TypeStringList))
+
+	kmsKey = "override_key"
+	defer func() { kmsKey = "" }()
+	assert.Equal(t, "override_key", kmsKeyFor("pci-payments"))
+	assert.Equal(t, "", kmsKeyForType("pci-payments", store.TypeString))
+}
+
+func TestApplyConfigEnvironment(t *testing.T) {
+	variables := []string{"CHAMBER_KMS_KEY_ALIAS", "CHAMBER_AWS_REGION", "CHAMBER_NO_PATHS"}
+	for _, variable := range variables {
+		if value, ok := os.LookupEnv(variable); ok {
+			defer os.Setenv(variable, value)
+		} else {
+			defer os.Unsetenv(variable)
+		}
+		os.Unsetenv(variable)
+	}
+
+	enabled := true
+	config = configSettings{KMSKeyAlias: "prod_key", Region: "us-west-2", NoPaths: &enabled}
+	defer func() { config = configSettings{} }()
+	assert.Nil(t, applyConfig(&cobra.Command{}, config))
+
+	// childEnv returns the environment of a command run by exec
+	childEnv := func() string {
+		cmd := osexec.Command("env")
+		cmd.Env = environ(os.Environ())
+		out, err := cmd.Output()
+		assert.Nil(t, err)
+		return string(out)
+	}
+
+	t.Run("Config settings should not be exported to commands", func(t *testing.T) {
+		env := childEnv()
+		for _, variable := range variables {
+			assert.NotContains(t, env, variable+"=")
+		}
+		assert.Equal(t, "us-west-2", authOptions().Region)
+		assert.True(t, noPaths())
+	})
+
+	t.Run("Variables set by the user should be passed to commands", func(t *testing.T) {
+		os.Setenv("CHAMBER_AWS_REGION", "eu-west-1")
+		defer os.Unsetenv("CHAMBER_AWS_REGION")
+
+		assert.Contains(t, childEnv(), "CHAMBER_AWS_REGION=eu-west-1\n")
+		assert.Equal(t, "", authOptions().Region)
+	})
+}
@@ -1,6 +1,9 @@
 package cmd
 
 import (
+	"bufio"
+	"fmt"
+	"os"
 	"strings"
 
 	"github.com/pkg/errors"
@@ -8,19 +11,53 @@ import (
 	"github.com/spf13/cobra"
 )
 
-// deleteCmd represents the delete command
-var deleteCmd = &cobra.Command{
-	Use:   "delete <service> <key>",
-	Short: "Delete a secret, including all versions",
-	Args:  cobra.ExactArgs(2),
-	RunE:  delete,
-}
+var (
+	deleteService string
+	confirmed     bool
+	dryRun        bool
+	noBackup      bool
+	backupFile    string
+	softDelete    bool
+
+	// deleteCmd represents the delete command
+	deleteCmd = &cobra.Command{
+		Use:   "delete <service> <key> | delete --service <service>",
+		Short: "Delete a secret, including all versions, or every secret of a service",
+		Long: `Delete a secret, including all versions, or every secret of a service.
+
+Secrets are deleted permanently.  With --soft, they are moved to a recycle
+bin instead, from where they can be restored with "chamber undelete" until
+they are purged with "chamber purge".`,
+		Args: func(cmd *cobra.Command, args []string) error {
+			if deleteService != "" {
+				return cobra.NoArgs(cmd, args)
+			}
+			return cobra.ExactArgs(2)(cmd, args)
+		},
+		RunE: delete,
+	}
+)
 
 func init() {
+	deleteCmd.Flags().StringVar(&deleteService, "service", "", "Delete every secret of the given service")
+	deleteCmd.Flags().BoolVar(&confirmed, "confirm", false, "Do not ask for confirmation before deleting a service")
+	deleteCmd.Flags().BoolVar(&dryRun, "dry-run", false, "Only print the secrets that would be deleted")
+	deleteCmd.Flags().BoolVar(&noBackup, "no-backup", false, "Do not back up the secrets of a service before deleting them")
+	deleteCmd.Flags().StringVar(&backupFile, "backup-file", "", "File to write an encrypted backup of the secrets of a service to, as chamber backup does (required unless --no-backup is given)")
+	deleteCmd.Flags().StringVar(&passphraseFile, "passphrase-file", "", "File containing the passphrase to encrypt the backup with (default is $CHAMBER_BACKUP_PASSPHRASE)")
+	deleteCmd.Flags().BoolVar(&softDelete, "soft", false, "Move to the recycle bin instead of deleting permanently")
 	RootCmd.AddCommand(deleteCmd)
 }
 
 func delete(cmd *cobra.Command, args []string) error {
+	if deleteService != "" {
+		service := strings.ToLower(deleteService)
+		if err := validateService(service); err != nil {
+			return errors.Wrap(err, "Failed to validate service")
+		}
+		return deleteWholeService(getSecretStore(), service)
+	}
+
 	service := strings.ToLower(args[0])
 	if err := validateService(service); err != nil {
 		return errors.Wrap(err, "Failed to validate service")
@@ -31,11 +68,110 @@ func delete(cmd *cobra.Command, args []string) error {
 		return errors.Wrap(err, "Failed to validate key")
 	}
 
-	secretStore := store.NewSSMStore(numRetries)
+	secretStore := getSecretStore()
 	secretId := store.SecretId{
 		Service: service,
 		Key:     key,
 	}
 
+	if dryRun {
+		if _, err := secretStore.Read(secretId, -1); err != nil {
+			return errors.Wrap(err, "Failed to read")
+		}
+		fmt.Fprintf(os.Stdout, "Would delete %s/%s\n", service, key)
+		return nil
+	}
+
+	return deleteSecret(secretStore, secretId)
+}
+
+// deleteSecret deletes a secret permanently, or moves it to the recycle bin if
+// --soft was given
+func deleteSecret(secretStore store.Store, secretId store.SecretId) error {
+	if softDelete {
+		return secretStore.SoftDelete(secretId)
+	}
 	return secretStore.Delete(secretId)
 }
+
+// deleteWholeService deletes every secret of service, after writing an
+// encrypted backup of them to --backup-file
+func deleteWholeService(secretStore store.Store, service string) error {
+	var passphrase []byte
+	if !dryRun && !noBackup {
+		if backupFile == "" {
+			return errors.New("a file to back up the secrets to must be given with --backup-file, or --no-backup to delete them without a backup")
+		}
+		var err error
+		if passphrase, err = readPassphrase(); err != nil {
+			return err
+		}
+	}
+
+	rawSecrets, err := secretStore.ListRaw(service)
+	if err != nil {
+		return errors.Wrap(err, "Failed to list store contents")
+	}
+	if len(rawSecrets) == 0 {
+		return fmt.Errorf("No secrets found for service %s", service)
+	}
+
+	params := make(map[string]string)
+	for _, rawSecret := range rawSecrets {
+		params[key(rawSecret.Key)] = rawSecret.Value
+	}
+
+	fmt.Fprintf(os.Stdout, "The following %d secrets of service %s will be deleted:\n", len(params), service)
+	for _, k := range sortedKeys(params) {
+		fmt.Fprintf(os.Stdout, "  %s\n", k)
+	}
+
+	if dryRun {
+		return nil
+	}
+
+	if !confirmed {
+		ok, err := promptConfirm(fmt.Sprintf("Delete all %d secrets of service %s?", len(params), service))
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return errors.New("Aborted")
+		}
+	}
+
+	if !noBackup {
+		b, count, err := backupServices(secretStore, []string{service})
+		if err == nil {
+			err = writeBackup(b, passphrase, backupFile)
+		}
+		if err != nil {
+			return errors.Wrap(err, "Failed to back up secrets, nothing was deleted")
+		}
+		fmt.Fprintf(os.Stdout, "Backed up %d secrets to %s\n", count, backupFile)
+	}
+
+	for _, k := range sortedKeys(params) {
+		secretId := store.SecretId{
+			Service: service,
+			Key:     k,
+		}
+		if err := deleteSecret(secretStore, secretId); err != nil {
+			return errors.Wrapf(err, "Failed to delete %s", k)
+		}
+	}
+
+	fmt.Fprintf(os.Stdout, "Successfully deleted %d secrets\n", len(params))
+	return nil
+}
+
+// promptConfirm asks the user a yes/no question on standard input
+func promptConfirm(question string) (bool, error) {
+	fmt.Fprintf(os.Stderr, "%s [y/N] ", question)
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && answer == "" {
+		return false, errors.Wrap(err, "Failed to read confirmation")
+	}
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes", nil
+}
@@ -0,0 +1,165 @@
+package cmd
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/segmentio/chamber/store"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeDeleteStore implements Delete and SoftDelete on top of a fakeStore,
+// counting the secrets moved to the recycle bin
+type fakeDeleteStore struct {
+	*fakeStore
+	softDeleted int
+}
+
+func (s *fakeDeleteStore) SoftDelete(id store.SecretId) error {
+	if err := s.Delete(id); err != nil {
+		return err
+	}
+	s.softDeleted++
+	return nil
+}
+
+func (s *fakeDeleteStore) Delete(id store.SecretId) error {
+	if _, ok := s.secrets[id]; !ok {
+		return store.ErrSecretNotFound
+	}
+	// The builtin delete is shadowed by the delete command
+	remaining := map[store.SecretId]string{}
+	for other, value := range s.secrets {
+		if other != id {
+			remaining[other] = value
+		}
+	}
+	s.secrets = remaining
+	return nil
+}
+
+func (s *fakeDeleteStore) List(service string, includeValues bool, includeTags bool) ([]store.Secret, error) {
+	secrets := []store.Secret{}
+	for id := range s.secrets {
+		if id.Service == service {
+			secret, _ := s.Read(id, -1)
+			secrets = append(secrets, secret)
+		}
+	}
+	return secrets, nil
+}
+
+func (s *fakeDeleteStore) Versions(id store.SecretId) ([]store.Secret, error) {
+	secret, err := s.Read(id, -1)
+	if err != nil {
+		return nil, err
+	}
+	secret.Meta.Type = store.TypeString
+	return []store.Secret{secret}, nil
+}
+
+func TestDeleteWholeService(t *testing.T) {
+	dir, err := ioutil.TempDir("", "chamber")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	newStore := func() *fakeDeleteStore {
+		return &fakeDeleteStore{fakeStore: &fakeStore{secrets: map[store.SecretId]string{
+			{Service: "billing", Key: "db_password"}: "hunter2",
+			{Service: "billing", Key: "api_key"}:     "abc",
+			{Service: "other", Key: "api_key"}:       "def",
+		}}}
+	}
+	passphrase := []byte("correct horse")
+	passphraseFile = filepath.Join(dir, "passphrase")
+	assert.Nil(t, ioutil.WriteFile(passphraseFile, passphrase, 0600))
+	confirmed = true
+	defer func() {
+		confirmed, dryRun, noBackup, backupFile, passphraseFile = false, false, false, "", ""
+	}()
+
+	t.Run("Deleting without a backup file or --no-backup should fail", func(t *testing.T) {
+		secretStore := newStore()
+		assert.NotNil(t, deleteWholeService(secretStore, "billing"))
+		assert.Equal(t, 3, len(secretStore.secrets))
+	})
+
+	t.Run("A dry run should not need a backup file", func(t *testing.T) {
+		dryRun = true
+		defer func() { dryRun = false }()
+
+		secretStore := newStore()
+		assert.Nil(t, deleteWholeService(secretStore, "billing"))
+		assert.Equal(t, 3, len(secretStore.secrets))
+	})
+
+	t.Run("Deleting without a backup passphrase should fail", func(t *testing.T) {
+		backupFile, passphraseFile = filepath.Join(dir, "nopassphrase.json"), ""
+		defer func() { passphraseFile = filepath.Join(dir, "passphrase") }()
+		os.Unsetenv("CHAMBER_BACKUP_PASSPHRASE")
+
+		secretStore := newStore()
+		assert.NotNil(t, deleteWholeService(secretStore, "billing"))
+		assert.Equal(t, 3, len(secretStore.secrets))
+		_, err := os.Stat(backupFile)
+		assert.True(t, os.IsNotExist(err))
+	})
+
+	t.Run("Secrets should be backed up to a private encrypted file before being deleted", func(t *testing.T) {
+		backupFile = filepath.Join(dir, "billing.json")
+		secretStore := newStore()
+		assert.Nil(t, deleteWholeService(secretStore, "billing"))
+		assert.Equal(t, 1, len(secretStore.secrets))
+
+		info, err := os.Stat(backupFile)
+		assert.Nil(t, err)
+		assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+		contents, err := ioutil.ReadFile(backupFile)
+		assert.Nil(t, err)
+		assert.NotContains(t, string(contents), "hunter2")
+		var encrypted encryptedBackup
+		assert.Nil(t, json.Unmarshal(contents, &encrypted))
+		plaintext, err := decryptBackup(encrypted, passphrase)
+		assert.Nil(t, err)
+		var b backup
+		assert.Nil(t, json.Unmarshal(plaintext, &b))
+
+		values := map[string]string{}
+		for _, secret := range b.Services["billing"] {
+			assert.Equal(t, 1, len(secret.Versions))
+			assert.Equal(t, store.TypeString, secret.Versions[0].Type)
+			values[secret.Key] = secret.Versions[0].Value
+		}
+		assert.Equal(t, map[string]string{"db_password": "hunter2", "api_key": "abc"}, values)
+	})
+
+	t.Run("An existing backup file should not be overwritten", func(t *testing.T) {
+		secretStore := newStore()
+		assert.NotNil(t, deleteWholeService(secretStore, "billing"))
+		assert.Equal(t, 3, len(secretStore.secrets))
+	})
+
+	t.Run("Deleting with --no-backup should not write a file", func(t *testing.T) {
+		noBackup, backupFile = true, ""
+		defer func() { noBackup = false }()
+
+		secretStore := newStore()
+		assert.Nil(t, deleteWholeService(secretStore, "billing"))
+		assert.Equal(t, 1, len(secretStore.secrets))
+		assert.Equal(t, 0, secretStore.softDeleted)
+	})
+
+	t.Run("Deleting with --soft should move the secrets to the recycle bin", func(t *testing.T) {
+		noBackup, softDelete = true, true
+		defer func() { noBackup, softDelete = false, false }()
+
+		secretStore := newStore()
+		assert.Nil(t, deleteWholeService(secretStore, "billing"))
+		assert.Equal(t, 1, len(secretStore.secrets))
+		assert.Equal(t, 2, secretStore.softDeleted)
+	})
+}
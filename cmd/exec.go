@@ -2,65 +2,203 @@ package cmd
 
 import (
 	"fmt"
+	"io/ioutil"
 	"os"
+	osexec "os/exec"
+	"os/signal"
+	"path/filepath"
 	"strings"
+	"syscall"
 
 	"github.com/pkg/errors"
 	"github.com/segmentio/chamber/store"
 	"github.com/spf13/cobra"
 )
 
-// execCmd represents the exec command
-var execCmd = &cobra.Command{
-	Use:   "exec <service...> -- <command> [<arg...>]",
-	Short: "Executes a command with secrets loaded into the environment",
-	Args: func(cmd *cobra.Command, args []string) error {
-		dashIx := cmd.ArgsLenAtDash()
-		if dashIx == -1 {
-			return errors.New("please separate services and command with '--'. See usage")
-		}
-		if err := cobra.MinimumNArgs(1)(cmd, args[:dashIx]); err != nil {
-			return errors.Wrap(err, "at least one service must be specified")
-		}
-		if err := cobra.MinimumNArgs(1)(cmd, args[dashIx:]); err != nil {
-			return errors.Wrap(err, "must specify command to run. See usage")
-		}
-		return nil
-	},
-	RunE: execRun,
-}
+var (
+	execFiles []string
+
+	// execCmd represents the exec command
+	execCmd = &cobra.Command{
+		Use:   "exec [<service...>] -- <command> [<arg...>]",
+		Short: "Executes a command with secrets loaded into the environment",
+		Args: func(cmd *cobra.Command, args []string) error {
+			dashIx := cmd.ArgsLenAtDash()
+			if dashIx == -1 {
+				return errors.New("please separate services and command with '--'. See usage")
+			}
+			if err := cobra.MinimumNArgs(1)(cmd, args[dashIx:]); err != nil {
+				return errors.Wrap(err, "must specify command to run. See usage")
+			}
+			return nil
+		},
+		RunE: execRun,
+	}
+)
 
 func init() {
+	execCmd.Flags().StringSliceVar(&execFiles, "file", []string{}, "Key of a secret to write to a temporary file, whose path is set in the environment instead of the value (can be repeated)")
+	execCmd.Flags().BoolVar(&noInterpolate, "no-interpolate", false, "Do not resolve ${ref:<service>/<key>} and ${env:<name>} references in values")
+	execCmd.Flags().BoolVar(&interpolateEnv, "interpolate-env", false, "Also resolve ${env:<name>} references in values, from the environment chamber runs in (default is to leave them as is)")
 	RootCmd.AddCommand(execCmd)
 }
 
 func execRun(cmd *cobra.Command, args []string) error {
 	dashIx := cmd.ArgsLenAtDash()
 	services, command, commandArgs := args[:dashIx], args[dashIx], args[dashIx+1:]
+	services, err := servicesOrDefault(services)
+	if err != nil {
+		return err
+	}
 
-	env := environ(os.Environ())
-	secretStore := store.NewSSMStore(numRetries)
-	for _, service := range services {
-		if err := validateService(service); err != nil {
-			return errors.Wrap(err, "Failed to validate service")
-		}
+	asFile := map[string]bool{}
+	for _, k := range execFiles {
+		asFile[strings.ToLower(k)] = false
+	}
+	var fileDir string
+
+	refs, err := parseServiceRefs(services)
+	if err != nil {
+		return err
+	}
+	names := make([]string, len(refs))
+	for i, ref := range refs {
+		names[i] = ref.String()
+	}
 
-		rawSecrets, err := secretStore.ListRaw(strings.ToLower(service))
+	secretStore := newAgentStore()
+	serviceSecrets := make([][]store.RawSecret, len(refs))
+	err = fetchServices(names, func(i int, name string) error {
+		var lister interface {
+			ListRaw(service string) ([]store.RawSecret, error)
+		} = secretStore
+		if refs[i].location() != "" {
+			s, err := locationStore(refs[i])
+			if err != nil {
+				return err
+			}
+			lister = s
+		}
+		rawSecrets, err := lister.ListRaw(refs[i].Service)
 		if err != nil {
-			return errors.Wrap(err, "Failed to list store contents")
+			return errors.Wrapf(err, "Failed to list store contents for service %s", name)
 		}
+		serviceSecrets[i] = rawSecrets
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if interpolationEnabled() {
+		if err := interpolateRawSecrets(newInterpolator(secretStore), refs, serviceSecrets); err != nil {
+			return err
+		}
+	}
+
+	env := environ(os.Environ())
+	for _, rawSecrets := range serviceSecrets {
 		for _, rawSecret := range rawSecrets {
-			envVarKey := strings.ToUpper(key(rawSecret.Key))
-			envVarKey = strings.Replace(envVarKey, "-", "_", -1)
+			k := key(rawSecret.Key)
+			envVarKey := envVarName(k)
+			value := rawSecret.Value
+
+			if _, ok := asFile[k]; ok {
+				asFile[k] = true
+				if fileDir == "" {
+					if fileDir, err = ioutil.TempDir("", "chamber"); err != nil {
+						return errors.Wrap(err, "Failed to create directory for secret files")
+					}
+					defer os.RemoveAll(fileDir)
+				}
+				if value, err = writeSecretFile(fileDir, k, value); err != nil {
+					return errors.Wrapf(err, "Failed to write %s to a file", k)
+				}
+			} else if store.IsBinary(value) {
+				fmt.Fprintf(os.Stderr, "warning: %s is binary and is set base64 encoded, use --file %s to get its content in a file\n", envVarKey, k)
+				value = strings.TrimPrefix(value, store.BinaryValuePrefix)
+			}
 
 			if env.IsSet(envVarKey) {
 				fmt.Fprintf(os.Stderr, "warning: overwriting environment variable %s\n", envVarKey)
 			}
-			env.Set(envVarKey, rawSecret.Value)
+			env.Set(envVarKey, value)
+		}
+	}
+
+	for k, written := range asFile {
+		if !written {
+			return errors.Errorf("Secret %s given with --file was not found", k)
 		}
 	}
 
-	return exec(command, commandArgs, env)
+	// Print the summary before the command replaces chamber, and only once
+	reportRetries()
+	verbose, debug = false, false
+
+	if fileDir == "" {
+		return exec(command, commandArgs, env)
+	}
+
+	// The command is run as a child rather than exec'd, so that the secret
+	// files can be removed once it exits
+	status, err := runCommand(command, commandArgs, env)
+	os.RemoveAll(fileDir)
+	if err != nil {
+		return err
+	}
+	if status != 0 {
+		os.Exit(status)
+	}
+	return nil
+}
+
+// writeSecretFile writes the content of a secret to a file only readable by
+// the current user, and returns its path
+func writeSecretFile(dir, k, value string) (string, error) {
+	data, err := store.DecodeValue(value)
+	if err != nil {
+		return "", err
+	}
+	file := filepath.Join(dir, k)
+	if err := ioutil.WriteFile(file, data, 0600); err != nil {
+		return "", err
+	}
+	return file, nil
+}
+
+// runCommand runs the given command as a child process, passing it args and
+// setting its environment to env, forwards signals to it and returns its exit
+// status once it exits.  It runs commands given secret files with --file, and
+// every command on platforms exec can't replace chamber on.
+func runCommand(command string, args []string, env []string) (int, error) {
+	ecmd := osexec.Command(command, args...)
+	ecmd.Stdin = os.Stdin
+	ecmd.Stdout = os.Stdout
+	ecmd.Stderr = os.Stderr
+	ecmd.Env = env
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan)
+
+	if err := ecmd.Start(); err != nil {
+		return 0, errors.Wrap(err, "Failed to start command")
+	}
+
+	go func() {
+		for {
+			sig := <-sigChan
+			ecmd.Process.Signal(sig)
+		}
+	}()
+
+	if err := ecmd.Wait(); err != nil {
+		if exitErr, ok := err.(*osexec.ExitError); ok {
+			return exitErr.Sys().(syscall.WaitStatus).ExitStatus(), nil
+		}
+		ecmd.Process.Signal(os.Kill)
+		return 0, errors.Wrap(err, "Failed to wait for command termination")
+	}
+	return 0, nil
 }
 
 // environ is a slice of strings representing the environment, in the form "key=value".
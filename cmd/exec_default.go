@@ -2,45 +2,16 @@
 
 package cmd
 
-import (
-	"os"
-	osexec "os/exec"
-	"os/signal"
-	"syscall"
-
-	"github.com/pkg/errors"
-)
+import "os"
 
 // exec executes the given command, passing it args and setting its environment
 // to env.
 // The exec function is allowed to never return and cause the program to exit.
 func exec(command string, args []string, env []string) error {
-	ecmd := osexec.Command(command, args...)
-	ecmd.Stdin = os.Stdin
-	ecmd.Stdout = os.Stdout
-	ecmd.Stderr = os.Stderr
-	ecmd.Env = env
-
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan)
-
-	if err := ecmd.Start(); err != nil {
-		return errors.Wrap(err, "Failed to start command")
+	status, err := runCommand(command, args, env)
+	if err != nil {
+		return err
 	}
-
-	go func() {
-		for {
-			sig := <-sigChan
-			ecmd.Process.Signal(sig)
-		}
-	}()
-
-	if err := ecmd.Wait(); err != nil {
-		ecmd.Process.Signal(os.Kill)
-		return errors.Wrap(err, "Failed to wait for command termination")
-	}
-
-	waitStatus := ecmd.ProcessState.Sys().(syscall.WaitStatus)
-	os.Exit(waitStatus.ExitStatus())
+	os.Exit(status)
 	return nil // unreachable but Go doesn't know about it
 }
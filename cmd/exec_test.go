@@ -0,0 +1,21 @@
+package cmd
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunCommandExitStatus(t *testing.T) {
+	status, err := runCommand("sh", []string{"-c", "exit 3"}, os.Environ())
+	assert.Nil(t, err)
+	assert.Equal(t, 3, status)
+
+	status, err = runCommand("sh", []string{"-c", "exit 0"}, os.Environ())
+	assert.Nil(t, err)
+	assert.Equal(t, 0, status)
+
+	_, err = runCommand("chamber-no-such-command", nil, os.Environ())
+	assert.NotNil(t, err)
+}
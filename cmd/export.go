@@ -2,62 +2,161 @@ package cmd
 
 import (
 	"bufio"
+	"bytes"
+	"encoding/base64"
 	"encoding/csv"
 	"encoding/json"
 	"fmt"
 	"io"
 	"os"
+	"regexp"
 	"sort"
 	"strings"
+	"time"
+	"unicode/utf8"
 
 	"github.com/magiconair/properties"
 	"github.com/pkg/errors"
 	"github.com/segmentio/chamber/store"
 	"github.com/spf13/cobra"
+	yaml "gopkg.in/yaml.v2"
 )
 
+// Regex's used to validate Kubernetes object names and data keys
+var (
+	validK8sNameFormat = regexp.MustCompile(`^[a-z0-9]([-a-z0-9.]{0,251}[a-z0-9])?$`)
+	validK8sKeyFormat  = regexp.MustCompile(`^[-._a-zA-Z0-9]+$`)
+)
+
+// Regex's used to validate shell variable names and Terraform identifiers
+var (
+	validShellNameFormat     = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	validTerraformNameFormat = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_-]*$`)
+)
+
+// exportFormats are the formats supported by exportParams, including aliases
+var exportFormats = []string{
+	"json", "yaml", "java-properties", "properties", "csv", "tsv", "dotenv",
+	"shell", "tfvars", "hcl", "docker-env", "k8s-secret", "k8s-configmap",
+}
+
 // exportCmd represents the export command
 var (
-	exportFormat string
-	exportOutput string
+	exportFormat    string
+	exportOutput    string
+	exportName      string
+	exportNamespace string
+	exportMetadata  bool
+	exportNested    bool
 
 	exportCmd = &cobra.Command{
-		Use:   "export <service...>",
+		Use:   "export [<service...>]",
 		Short: "Exports parameters in the specified format",
-		Args:  cobra.MinimumNArgs(1),
 		RunE:  runExport,
 	}
 )
 
 func init() {
-	exportCmd.Flags().StringVarP(&exportFormat, "format", "f", "json", "Output format (json, java-properties, csv, tsv, dotenv)")
+	exportCmd.Flags().StringVarP(&exportFormat, "format", "f", "json", "Output format (json, yaml, java-properties, csv, tsv, dotenv, shell, tfvars, docker-env, k8s-secret, k8s-configmap)")
 	exportCmd.Flags().StringVarP(&exportOutput, "output-file", "o", "", "Output file (default is standard output)")
+	exportCmd.Flags().StringVar(&exportName, "name", "", "Name of the exported Kubernetes object (default is the first service)")
+	exportCmd.Flags().StringVar(&exportNamespace, "namespace", "", "Namespace of the exported Kubernetes object")
+	exportCmd.Flags().BoolVar(&exportMetadata, "with-metadata", false, "Export the source service, version, modifier and modification time of each secret along with its value (json and yaml only)")
+	exportCmd.Flags().BoolVar(&exportNested, "nested", false, "Keep the secrets of each service separate instead of merging them (json and yaml only)")
+	exportCmd.Flags().BoolVar(&noInterpolate, "no-interpolate", false, "Do not resolve ${ref:<service>/<key>} and ${env:<name>} references in values")
+	exportCmd.Flags().BoolVar(&interpolateEnv, "interpolate-env", false, "Also resolve ${env:<name>} references in values, from the environment chamber runs in (default is to leave them as is)")
 	RootCmd.AddCommand(exportCmd)
 }
 
+// exportedSecret is a secret exported with --with-metadata
+type exportedSecret struct {
+	Service string `json:"service" yaml:"service"`
+	Type    string `json:"type" yaml:"type"`
+	// Encoding is base64 for binary secrets
+	Encoding string `json:"encoding,omitempty" yaml:"encoding,omitempty"`
+	// Value is the value of the secret, or the list of its items if it is
+	// a StringList
+	Value      interface{} `json:"value" yaml:"value"`
+	Version    int         `json:"version" yaml:"version"`
+	ModifiedBy string      `json:"modified_by" yaml:"modified_by"`
+	Modified   time.Time   `json:"modified" yaml:"modified"`
+
+	// raw is the value as stored, with the items of a StringList comma
+	// separated and binary content base64 encoded
+	raw string
+}
+
+func newExportedSecret(service, value, paramType string) exportedSecret {
+	secret := exportedSecret{
+		Service: service,
+		Type:    paramType,
+		Value:   value,
+		raw:     value,
+	}
+	if store.IsBinary(value) {
+		secret.Encoding = "base64"
+		secret.raw = strings.TrimPrefix(value, store.BinaryValuePrefix)
+		secret.Value = secret.raw
+	} else if paramType == store.TypeStringList {
+		secret.Value = strings.Split(value, ",")
+	}
+	return secret
+}
+
 func runExport(cmd *cobra.Command, args []string) error {
-	var err error
+	args, err := servicesOrDefault(args)
+	if err != nil {
+		return err
+	}
 
-	secretStore := store.NewSSMStore(numRetries)
-	params := make(map[string]string)
-	for _, service := range args {
-		if err := validateService(service); err != nil {
-			return errors.Wrapf(err, "Failed to validate service %s", service)
-		}
+	format := strings.ToLower(exportFormat)
+	if err := validateExportFormat(format); err != nil {
+		return err
+	}
+	if (exportMetadata || exportNested) && format != "json" && format != "yaml" {
+		return errors.New("--with-metadata and --nested are only supported by the json and yaml formats")
+	}
+
+	refs, err := parseServiceRefs(args)
+	if err != nil {
+		return err
+	}
+	// names are the services as given, and services their names only
+	names := make([]string, len(refs))
+	services := make([]string, len(refs))
+	for i, ref := range refs {
+		names[i] = ref.String()
+		services[i] = ref.Service
+	}
 
-		rawSecrets, err := secretStore.ListRaw(strings.ToLower(service))
+	secretStore := getSecretStore()
+	listed := make([]map[string]exportedSecret, len(refs))
+	err = fetchServices(names, func(i int, name string) error {
+		s := secretStore
+		if refs[i].location() != "" {
+			var err error
+			if s, err = locationStore(refs[i]); err != nil {
+				return err
+			}
+		}
+		serviceSecrets, err := listExportedSecrets(s, refs[i].Service)
 		if err != nil {
-			return errors.Wrapf(err, "Failed to list store contents for service %s", service)
+			return errors.Wrapf(err, "Failed to list store contents for service %s", name)
 		}
-		for _, rawSecret := range rawSecrets {
-			k := key(rawSecret.Key)
-			if _, ok := params[k]; ok {
-				fmt.Fprintf(os.Stderr, "warning: parameter %s specified more than once (overriden by service %s)\n", k, service)
-			}
-			params[k] = rawSecret.Value
+		listed[i] = serviceSecrets
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if interpolationEnabled() {
+		if err := interpolateExportedSecrets(newInterpolator(secretStore), refs, listed); err != nil {
+			return err
 		}
 	}
 
+	secrets, nested := mergeExportedSecrets(names, listed)
+
 	file := os.Stdout
 	if exportOutput != "" {
 		if file, err = os.OpenFile(exportOutput, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644); err != nil {
@@ -69,9 +168,160 @@ func runExport(cmd *cobra.Command, args []string) error {
 	w := bufio.NewWriter(file)
 	defer w.Flush()
 
-	switch strings.ToLower(exportFormat) {
+	switch {
+	case exportNested && exportMetadata:
+		err = exportStructured(nested, format, w)
+	case exportNested:
+		values := make(map[string]map[string]interface{}, len(nested))
+		for service, serviceSecrets := range nested {
+			values[service] = make(map[string]interface{}, len(serviceSecrets))
+			for k, secret := range serviceSecrets {
+				values[service][k] = secret.Value
+			}
+		}
+		err = exportStructured(values, format, w)
+	case exportMetadata:
+		err = exportStructured(secrets, format, w)
+	default:
+		err = exportParams(secrets, format, services, w)
+	}
+
+	if err != nil {
+		return errors.Wrap(err, "Unable to export parameters")
+	}
+
+	return nil
+}
+
+// validateExportFormat fails for formats exportParams doesn't support, so that
+// they are rejected before any secret is read
+func validateExportFormat(format string) error {
+	for _, supported := range exportFormats {
+		if format == supported {
+			return nil
+		}
+	}
+	return errors.Errorf("Unsupported export format: %s", exportFormat)
+}
+
+// mergeExportedSecrets merges the secrets listed for services, in order, so
+// that a key of a later service overrides the same key of an earlier one.
+// The secrets of each service are also returned apart, keyed by service, for
+// --nested.
+func mergeExportedSecrets(services []string, listed []map[string]exportedSecret) (map[string]exportedSecret, map[string]map[string]exportedSecret) {
+	secrets := make(map[string]exportedSecret)
+	nested := make(map[string]map[string]exportedSecret)
+	for i, service := range services {
+		serviceSecrets := listed[i]
+		nested[service] = serviceSecrets
+		for _, k := range sortedExportedKeys(serviceSecrets) {
+			secret := serviceSecrets[k]
+			if _, ok := secrets[k]; ok && !exportNested {
+				fmt.Fprintf(os.Stderr, "warning: parameter %s specified more than once (overriden by service %s)\n", k, service)
+			}
+			secrets[k] = secret
+		}
+	}
+	return secrets, nested
+}
+
+// listExportedSecrets lists the secrets of a service, including their
+// metadata if --with-metadata was given
+func listExportedSecrets(secretStore store.Store, service string) (map[string]exportedSecret, error) {
+	secrets := make(map[string]exportedSecret)
+	if !exportMetadata {
+		rawSecrets, err := secretStore.ListRaw(service)
+		if err != nil {
+			return nil, err
+		}
+		for _, rawSecret := range rawSecrets {
+			secrets[key(rawSecret.Key)] = newExportedSecret(service, rawSecret.Value, rawSecret.Type)
+		}
+		return secrets, nil
+	}
+
+	list, err := secretStore.List(service, true, false)
+	if err != nil {
+		return nil, err
+	}
+	for _, secret := range list {
+		exported := newExportedSecret(service, *secret.Value, secret.Meta.Type)
+		exported.Version = secret.Meta.Version
+		exported.ModifiedBy = secret.Meta.CreatedBy
+		exported.Modified = secret.Meta.Created.UTC()
+		secrets[key(secret.Meta.Key)] = exported
+	}
+	return secrets, nil
+}
+
+// interpolateExportedSecrets resolves the references in the values of the
+// secrets of services, listed in the order of refs
+func interpolateExportedSecrets(in *interpolator, refs []serviceRef, listed []map[string]exportedSecret) error {
+	for i, serviceSecrets := range listed {
+		for k, secret := range serviceSecrets {
+			if secret.Encoding == "" {
+				in.add(refs[i], k, secret.raw)
+			}
+		}
+	}
+	for i, serviceSecrets := range listed {
+		for _, k := range sortedExportedKeys(serviceSecrets) {
+			secret := serviceSecrets[k]
+			if secret.Encoding != "" {
+				continue
+			}
+			value, err := in.interpolate(refs[i], k, secret.raw)
+			if err != nil {
+				return err
+			}
+			resolved := newExportedSecret(secret.Service, value, secret.Type)
+			secret.Value, secret.raw = resolved.Value, resolved.raw
+			serviceSecrets[k] = secret
+		}
+	}
+	return nil
+}
+
+// exportStructured writes v as json or yaml
+func exportStructured(v interface{}, format string, w io.Writer) error {
+	if format == "yaml" {
+		return exportAsYaml(v, w)
+	}
+	return exportAsJson(v, w)
+}
+
+// exportParams writes the values of secrets in format.  StringList secrets
+// are written as lists in the json and yaml formats, and comma separated in
+// the other formats.  Binary secrets are written base64 encoded, except in
+// the Kubernetes formats which support binary content.
+func exportParams(secrets map[string]exportedSecret, format string, services []string, w io.Writer) error {
+	binaryContent := format == "k8s-secret" || format == "k8s-configmap"
+	params := make(map[string]string, len(secrets))
+	values := make(map[string]interface{}, len(secrets))
+	for _, k := range sortedExportedKeys(secrets) {
+		secret := secrets[k]
+		params[k] = secret.raw
+		values[k] = secret.Value
+		if secret.Encoding == "" {
+			continue
+		}
+		if binaryContent {
+			data, err := base64.StdEncoding.DecodeString(secret.raw)
+			if err != nil {
+				return errors.Wrapf(err, "Failed to decode binary param %s", k)
+			}
+			params[k] = string(data)
+		} else if format != "json" && format != "yaml" {
+			fmt.Fprintf(os.Stderr, "warning: parameter %s is binary and is exported base64 encoded\n", k)
+		}
+	}
+
+	var err error
+	switch format {
 	case "json":
-		err = exportAsJson(params, w)
+		err = exportAsJson(values, w)
+	case "yaml":
+		err = exportAsYaml(values, w)
 	case "java-properties", "properties":
 		err = exportAsJavaProperties(params, w)
 	case "csv":
@@ -80,36 +330,156 @@ func runExport(cmd *cobra.Command, args []string) error {
 		err = exportAsTsv(params, w)
 	case "dotenv":
 		err = exportAsEnvFile(params, w)
+	case "shell":
+		err = exportAsShell(params, w)
+	case "tfvars", "hcl":
+		err = exportAsTfvars(params, w)
+	case "docker-env":
+		err = exportAsDockerEnvFile(params, w)
+	case "k8s-secret":
+		err = exportAsK8sSecret(params, k8sObjectName(services), exportNamespace, w)
+	case "k8s-configmap":
+		err = exportAsK8sConfigMap(params, k8sObjectName(services), exportNamespace, w)
 	default:
 		err = errors.Errorf("Unsupported export format: %s", exportFormat)
 	}
+	return err
+}
 
-	if err != nil {
-		return errors.Wrap(err, "Unable to export parameters")
+func exportAsEnvFile(params map[string]string, w io.Writer) error {
+	// Env File like:
+	// KEY='VAL $NOT_EXPANDED'
+	// OTHER="OTHER \"VAL\"\nON TWO LINES"
+	// Values are single quoted, which dotenv loaders keep literal.  Values
+	// single quotes can't hold, with a newline, a single quote or a
+	// backslash, are double quoted instead, in which loaders expand $VAR, so
+	// they can't contain a dollar sign.
+	for _, k := range sortedKeys(params) {
+		value := params[k]
+		line := fmt.Sprintf("%s='%s'\n", envVarName(k), value)
+		if strings.ContainsAny(value, "\r\n'\\") {
+			if strings.Contains(value, "$") {
+				return errors.Errorf("Parameter %s contains a dollar sign along with a newline, a single quote or a backslash, which dotenv loaders would expand", k)
+			}
+			line = fmt.Sprintf("%s=\"%s\"\n", envVarName(k), dotenvEscaper.Replace(value))
+		}
+		if _, err := io.WriteString(w, line); err != nil {
+			return errors.Wrapf(err, "Failed to write param %s to env file", k)
+		}
 	}
+	return nil
+}
 
+// dotenvEscaper escapes a value for use in a double quoted dotenv value.
+// Only the escapes that dotenv loaders unescape are used: other characters
+// would keep their backslash once loaded.
+var dotenvEscaper = strings.NewReplacer(
+	`\`, `\\`,
+	`"`, `\"`,
+	"\n", `\n`,
+	"\r", `\r`,
+)
+
+func exportAsShell(params map[string]string, w io.Writer) error {
+	// Shell script suitable for eval like:
+	// export KEY='VAL'
+	// export OTHER='it'\''s'
+	for _, k := range sortedKeys(params) {
+		name := envVarName(k)
+		if !validShellNameFormat.MatchString(name) {
+			return errors.Errorf("Parameter %s is not a valid shell variable name", k)
+		}
+		// Nothing is special inside single quotes except the single quote
+		// itself, which is written by closing the quotes, escaping it and
+		// reopening them
+		value := strings.Replace(params[k], "'", `'\''`, -1)
+		if _, err := fmt.Fprintf(w, "export %s='%s'\n", name, value); err != nil {
+			return errors.Wrapf(err, "Failed to write param %s to shell script", k)
+		}
+	}
 	return nil
 }
 
-func exportAsEnvFile(params map[string]string, w io.Writer) error {
-	// Env File like:
+func exportAsTfvars(params map[string]string, w io.Writer) error {
+	// Terraform variable definitions like:
+	// param1 = "value1"
+	// param2 = "value2"
+	for _, k := range sortedKeys(params) {
+		if !validTerraformNameFormat.MatchString(k) {
+			return errors.Errorf("Parameter %s is not a valid Terraform variable name", k)
+		}
+		if _, err := fmt.Fprintf(w, "%s = \"%s\"\n", k, hclEscape(params[k])); err != nil {
+			return errors.Wrapf(err, "Failed to write param %s to tfvars file", k)
+		}
+	}
+	return nil
+}
+
+// hclEscape escapes a value for use in a HCL quoted string, including the
+// template sequences ${ and %{ so that values are never interpolated
+func hclEscape(value string) string {
+	var b bytes.Buffer
+	for i, r := range value {
+		switch {
+		case r == '\\':
+			b.WriteString(`\\`)
+		case r == '"':
+			b.WriteString(`\"`)
+		case r == '\n':
+			b.WriteString(`\n`)
+		case r == '\r':
+			b.WriteString(`\r`)
+		case r == '\t':
+			b.WriteString(`\t`)
+		case (r == '$' || r == '%') && strings.HasPrefix(value[i+1:], "{"):
+			b.WriteRune(r)
+			b.WriteRune(r)
+		case r < 0x20 || r == 0x7f:
+			fmt.Fprintf(&b, `\u%04x`, r)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+func exportAsDockerEnvFile(params map[string]string, w io.Writer) error {
+	// Env file for docker run --env-file like:
 	// KEY=VAL
-	// OTHER=OTHERVAL
+	// OTHER=OTHER VAL
+	// Docker takes everything after the = literally and supports no quoting
+	// or escaping, so values spanning several lines can not be represented.
 	for _, k := range sortedKeys(params) {
-		key := strings.ToUpper(k)
-		key = strings.Replace(key, "-", "_", -1)
-		w.Write([]byte(fmt.Sprintf("%s=%s\n", key, params[k])))
+		if strings.ContainsAny(params[k], "\r\n") {
+			return errors.Errorf("Parameter %s contains a newline, which docker env files do not support", k)
+		}
+		if _, err := fmt.Fprintf(w, "%s=%s\n", envVarName(k), params[k]); err != nil {
+			return errors.Wrapf(err, "Failed to write param %s to docker env file", k)
+		}
 	}
 	return nil
 }
 
-func exportAsJson(params map[string]string, w io.Writer) error {
+func exportAsJson(params interface{}, w io.Writer) error {
 	// JSON like:
 	// {"param1":"value1","param2":"value2"}
 	// NOTE: json encoder does sorting by key
 	return json.NewEncoder(w).Encode(params)
 }
 
+func exportAsYaml(params interface{}, w io.Writer) error {
+	// YAML like:
+	// param1: value1
+	// param2: value2
+	// NOTE: yaml marshaller does sorting by key
+	out, err := yaml.Marshal(params)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(out)
+	return err
+}
+
 func exportAsJavaProperties(params map[string]string, w io.Writer) error {
 	// Java Properties like:
 	// param1 = value1
@@ -152,6 +522,121 @@ func exportAsTsv(params map[string]string, w io.Writer) error {
 	return nil
 }
 
+// k8sObject is a Kubernetes Secret or ConfigMap
+type k8sObject struct {
+	APIVersion string            `yaml:"apiVersion"`
+	Kind       string            `yaml:"kind"`
+	Metadata   k8sObjectMeta     `yaml:"metadata"`
+	Type       string            `yaml:"type,omitempty"`
+	Data       map[string]string `yaml:"data"`
+	BinaryData map[string]string `yaml:"binaryData,omitempty"`
+}
+
+type k8sObjectMeta struct {
+	Name      string `yaml:"name"`
+	Namespace string `yaml:"namespace,omitempty"`
+}
+
+func k8sObjectName(services []string) string {
+	if exportName != "" {
+		return exportName
+	}
+	return strings.ToLower(services[0])
+}
+
+func exportAsK8sSecret(params map[string]string, name, namespace string, w io.Writer) error {
+	// Kubernetes Secret manifest like:
+	// apiVersion: v1
+	// kind: Secret
+	// metadata:
+	//   name: service
+	// type: Opaque
+	// data:
+	//   param1: dmFsdWUx
+	data := make(map[string]string, len(params))
+	for k, v := range params {
+		data[k] = base64.StdEncoding.EncodeToString([]byte(v))
+	}
+	return writeK8sObject(k8sObject{
+		APIVersion: "v1",
+		Kind:       "Secret",
+		Metadata:   k8sObjectMeta{Name: name, Namespace: namespace},
+		Type:       "Opaque",
+		Data:       data,
+	}, w)
+}
+
+func exportAsK8sConfigMap(params map[string]string, name, namespace string, w io.Writer) error {
+	// Kubernetes ConfigMap manifest like:
+	// apiVersion: v1
+	// kind: ConfigMap
+	// metadata:
+	//   name: service
+	// data:
+	//   param1: value1
+	// Values that are not valid UTF-8 go to binaryData, base64 encoded
+	data := make(map[string]string, len(params))
+	binaryData := make(map[string]string)
+	for k, v := range params {
+		if utf8.ValidString(v) {
+			data[k] = v
+		} else {
+			binaryData[k] = base64.StdEncoding.EncodeToString([]byte(v))
+		}
+	}
+	return writeK8sObject(k8sObject{
+		APIVersion: "v1",
+		Kind:       "ConfigMap",
+		Metadata:   k8sObjectMeta{Name: name, Namespace: namespace},
+		Data:       data,
+		BinaryData: binaryData,
+	}, w)
+}
+
+func writeK8sObject(object k8sObject, w io.Writer) error {
+	if !validK8sNameFormat.MatchString(object.Metadata.Name) {
+		return errors.Errorf("Invalid Kubernetes object name %s", object.Metadata.Name)
+	}
+	for _, data := range []map[string]string{object.Data, object.BinaryData} {
+		for k := range data {
+			if !validK8sKeyFormat.MatchString(k) {
+				return errors.Errorf("Parameter %s is not a valid Kubernetes %s key", k, object.Kind)
+			}
+		}
+	}
+
+	out, err := yaml.Marshal(object)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(out)
+	return err
+}
+
+// envVarName converts a key to the name of the environment variable it is
+// exposed as, e.g. db-password becomes DB_PASSWORD, following the env naming
+// rules of the config file
+func envVarName(k string) string {
+	name := strings.Replace(k, "-", "_", -1)
+	switch config.Env.Case {
+	case "lower":
+		name = strings.ToLower(name)
+	case "preserve":
+	default:
+		name = strings.ToUpper(name)
+	}
+	return config.Env.Prefix + name
+}
+
+func sortedExportedKeys(secrets map[string]exportedSecret) []string {
+	keys := make([]string, 0, len(secrets))
+	for k := range secrets {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
 func sortedKeys(params map[string]string) []string {
 	keys := make([]string, len(params))
 	i := 0
@@ -0,0 +1,493 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	osexec "os/exec"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/segmentio/chamber/store"
+	"github.com/stretchr/testify/assert"
+	yaml "gopkg.in/yaml.v2"
+)
+
+func TestExportAsK8sSecret(t *testing.T) {
+	params := map[string]string{
+		"db_password": "p@ss: word\n#1",
+		"api-key":     "abc",
+	}
+
+	buf := &bytes.Buffer{}
+	err := exportAsK8sSecret(params, "api", "production", buf)
+	assert.Nil(t, err)
+
+	var object k8sObject
+	assert.Nil(t, yaml.Unmarshal(buf.Bytes(), &object))
+	assert.Equal(t, "v1", object.APIVersion)
+	assert.Equal(t, "Secret", object.Kind)
+	assert.Equal(t, "Opaque", object.Type)
+	assert.Equal(t, k8sObjectMeta{Name: "api", Namespace: "production"}, object.Metadata)
+	assert.Equal(t, len(params), len(object.Data))
+	for k, v := range params {
+		decoded, err := base64.StdEncoding.DecodeString(object.Data[k])
+		assert.Nil(t, err)
+		assert.Equal(t, v, string(decoded))
+	}
+}
+
+func TestExportAsK8sConfigMap(t *testing.T) {
+	params := map[string]string{
+		"hostname": "db.example.com",
+		"port":     "5432",
+		"flags":    "- not: a list\n",
+	}
+
+	buf := &bytes.Buffer{}
+	err := exportAsK8sConfigMap(params, "api", "", buf)
+	assert.Nil(t, err)
+	assert.NotContains(t, buf.String(), "namespace")
+
+	var object k8sObject
+	assert.Nil(t, yaml.Unmarshal(buf.Bytes(), &object))
+	assert.Equal(t, "ConfigMap", object.Kind)
+	assert.Equal(t, "", object.Type)
+	assert.Equal(t, params, object.Data)
+
+	t.Run("Invalid object names should be rejected", func(t *testing.T) {
+		err := exportAsK8sConfigMap(params, "Not_Valid", "", &bytes.Buffer{})
+		assert.NotNil(t, err)
+	})
+}
+
+// exportTestParams are values that are awkward to escape in one format or
+// another
+var exportTestParams = map[string]string{
+	"plain":     "value",
+	"spaces":    "  leading and trailing  ",
+	"comment":   "before # after",
+	"quotes":    `it's "quoted"`,
+	"backslash": `C:\path\n\\`,
+	"newlines":  "line1\nline2\r\n",
+	"dollar":    "$HOME ${HOME} `id` $(id)",
+	"template":  "${var.x} %{if true}",
+	"equals":    "a=b=c",
+	"unicode":   "ünïcödé ☃\ttab",
+	"yaml-ish":  "yes",
+	"empty":     "",
+}
+
+func TestExportAsYamlRoundTrip(t *testing.T) {
+	buf := &bytes.Buffer{}
+	assert.Nil(t, exportAsYaml(exportTestParams, buf))
+
+	parsed := map[string]string{}
+	assert.Nil(t, yaml.Unmarshal(buf.Bytes(), &parsed))
+	assert.Equal(t, exportTestParams, parsed)
+}
+
+func TestExportAsShellRoundTrip(t *testing.T) {
+	sh, err := osexec.LookPath("sh")
+	if err != nil {
+		t.Skip("sh is not available")
+	}
+
+	buf := &bytes.Buffer{}
+	assert.Nil(t, exportAsShell(exportTestParams, buf))
+
+	// eval the export and print every variable NUL terminated
+	keys := sortedKeys(exportTestParams)
+	script := `eval "$1"` + "\n"
+	for _, k := range keys {
+		script += fmt.Sprintf(`printf '%%s\0' "$%s"`+"\n", envVarName(k))
+	}
+	out, err := osexec.Command(sh, "-c", script, "sh", buf.String()).Output()
+	assert.Nil(t, err)
+
+	values := strings.Split(string(out), "\x00")
+	assert.Equal(t, len(keys)+1, len(values))
+	for i, k := range keys {
+		assert.Equal(t, exportTestParams[k], values[i], k)
+	}
+
+	t.Run("Keys that are not valid variable names should be rejected", func(t *testing.T) {
+		err := exportAsShell(map[string]string{"1key": "value"}, &bytes.Buffer{})
+		assert.NotNil(t, err)
+	})
+}
+
+func TestExportAsEnvFileRoundTrip(t *testing.T) {
+	buf := &bytes.Buffer{}
+	assert.Nil(t, exportAsEnvFile(exportTestParams, buf))
+
+	// Single quoted values are taken literally, and double quoted values are
+	// decoded like python-dotenv decodes them, where only \\, \', \", \a,
+	// \b, \f, \n, \r, \t and \v are escapes and any other backslash is kept
+	dotenvEscapes := regexp.MustCompile(`\\[\\'"abfnrtv]`)
+	dotenvUnescaped := map[string]string{
+		`\\`: `\`, `\'`: "'", `\"`: `"`, `\a`: "\a", `\b`: "\b",
+		`\f`: "\f", `\n`: "\n", `\r`: "\r", `\t`: "\t", `\v`: "\v",
+	}
+	parsed := map[string]string{}
+	for _, line := range strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n") {
+		parts := strings.SplitN(line, "=", 2)
+		if !assert.Equal(t, 2, len(parts), line) {
+			continue
+		}
+		quoted := parts[1]
+		if len(quoted) >= 2 && quoted[0] == '\'' && quoted[len(quoted)-1] == '\'' {
+			unquoted := quoted[1 : len(quoted)-1]
+			assert.NotContains(t, unquoted, `\`, line)
+			parsed[parts[0]] = unquoted
+			continue
+		}
+		if !assert.True(t, len(quoted) >= 2 && quoted[0] == '"' && quoted[len(quoted)-1] == '"', line) {
+			continue
+		}
+		assert.NotContains(t, quoted, "$", line)
+		parsed[parts[0]] = dotenvEscapes.ReplaceAllStringFunc(quoted[1:len(quoted)-1], func(escape string) string {
+			return dotenvUnescaped[escape]
+		})
+	}
+
+	assert.Equal(t, len(exportTestParams), len(parsed))
+	for k, v := range exportTestParams {
+		assert.Equal(t, v, parsed[envVarName(k)], k)
+	}
+	assert.Contains(t, buf.String(), "DOLLAR='$HOME ${HOME} `id` $(id)'\n")
+	assert.Contains(t, buf.String(), "TEMPLATE='${var.x} %{if true}'\n")
+
+	t.Run("Values that must be double quoted should not contain dollar signs", func(t *testing.T) {
+		for _, value := range []string{"$HOME\n", "it's $HOME", `C:\$HOME`} {
+			err := exportAsEnvFile(map[string]string{"dollar": value}, &bytes.Buffer{})
+			assert.NotNil(t, err, value)
+		}
+	})
+}
+
+func TestExportAsTfvarsRoundTrip(t *testing.T) {
+	buf := &bytes.Buffer{}
+	assert.Nil(t, exportAsTfvars(exportTestParams, buf))
+	assert.NotContains(t, buf.String(), "\"${var.x}")
+
+	parsed := parseQuotedAssignments(t, buf.String(), " = ", map[string]string{
+		`\\`: `\`, `\"`: `"`, `\n`: "\n", `\r`: "\r", `\t`: "\t", "$${": "${", "%%{": "%{",
+	})
+	assert.Equal(t, exportTestParams, parsed)
+}
+
+func TestExportAsDockerEnvFileRoundTrip(t *testing.T) {
+	params := map[string]string{}
+	for k, v := range exportTestParams {
+		if k != "newlines" {
+			params[k] = v
+		}
+	}
+
+	buf := &bytes.Buffer{}
+	assert.Nil(t, exportAsDockerEnvFile(params, buf))
+
+	// docker takes everything after the first = literally
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	assert.Equal(t, len(params), len(lines))
+	parsed := map[string]string{}
+	for _, line := range lines {
+		parts := strings.SplitN(line, "=", 2)
+		parsed[parts[0]] = parts[1]
+	}
+	for k, v := range params {
+		assert.Equal(t, v, parsed[envVarName(k)], k)
+	}
+
+	t.Run("Values with newlines should be rejected", func(t *testing.T) {
+		err := exportAsDockerEnvFile(exportTestParams, &bytes.Buffer{})
+		assert.NotNil(t, err)
+	})
+}
+
+// parseQuotedAssignments parses lines of the form key<sep>"value", undoing
+// the given escape sequences in the value
+func parseQuotedAssignments(t *testing.T, s, sep string, escapes map[string]string) map[string]string {
+	parsed := map[string]string{}
+	for _, line := range strings.Split(strings.TrimSuffix(s, "\n"), "\n") {
+		parts := strings.SplitN(line, sep, 2)
+		if !assert.Equal(t, 2, len(parts), line) {
+			continue
+		}
+		quoted := parts[1]
+		if !assert.True(t, len(quoted) >= 2 && quoted[0] == '"' && quoted[len(quoted)-1] == '"', line) {
+			continue
+		}
+		quoted = quoted[1 : len(quoted)-1]
+
+		value := ""
+	scan:
+		for len(quoted) > 0 {
+			for escaped, unescaped := range escapes {
+				if strings.HasPrefix(quoted, escaped) {
+					value += unescaped
+					quoted = quoted[len(escaped):]
+					continue scan
+				}
+			}
+			assert.False(t, quoted[0] == '"' || quoted[0] == '\\', "unescaped character in %s", line)
+			value += quoted[:1]
+			quoted = quoted[1:]
+		}
+		parsed[parts[0]] = value
+	}
+	return parsed
+}
+
+func TestValidateExportFormat(t *testing.T) {
+	secrets := map[string]exportedSecret{
+		"hostname": newExportedSecret("api", "db.example.com", store.TypeString),
+	}
+	for _, format := range exportFormats {
+		assert.Nil(t, validateExportFormat(format), format)
+		assert.Nil(t, exportParams(secrets, format, []string{"api"}, &bytes.Buffer{}), format)
+	}
+
+	assert.NotNil(t, validateExportFormat("jsn"))
+	assert.NotNil(t, validateExportFormat(""))
+}
+
+func TestExportStringLists(t *testing.T) {
+	secrets := map[string]exportedSecret{
+		"hosts":    newExportedSecret("api", "a.example.com,b.example.com", store.TypeStringList),
+		"hostname": newExportedSecret("api", "db.example.com", store.TypeString),
+	}
+
+	t.Run("StringLists should be exported as json arrays", func(t *testing.T) {
+		buf := &bytes.Buffer{}
+		assert.Nil(t, exportParams(secrets, "json", []string{"api"}, buf))
+		assert.Equal(t, `{"hostname":"db.example.com","hosts":["a.example.com","b.example.com"]}`+"\n", buf.String())
+	})
+
+	t.Run("StringLists should be comma separated in env files", func(t *testing.T) {
+		buf := &bytes.Buffer{}
+		assert.Nil(t, exportParams(secrets, "dotenv", []string{"api"}, buf))
+		assert.Equal(t, "HOSTNAME='db.example.com'\nHOSTS='a.example.com,b.example.com'\n", buf.String())
+	})
+}
+
+func TestExportBinary(t *testing.T) {
+	data := []byte{0x00, 0xff, 0xfe, 'k', 'e', 'y'}
+	secrets := map[string]exportedSecret{
+		"keystore": newExportedSecret("api", store.EncodeBinary(data), store.TypeSecureString),
+		"hostname": newExportedSecret("api", "db.example.com", store.TypeString),
+	}
+	payload := base64.StdEncoding.EncodeToString(data)
+
+	t.Run("Binary values should be exported base64 encoded", func(t *testing.T) {
+		buf := &bytes.Buffer{}
+		assert.Nil(t, exportParams(secrets, "dotenv", []string{"api"}, buf))
+		assert.Equal(t, "HOSTNAME='db.example.com'\nKEYSTORE='"+payload+"'\n", buf.String())
+	})
+
+	t.Run("Kubernetes secrets should hold the binary content", func(t *testing.T) {
+		buf := &bytes.Buffer{}
+		assert.Nil(t, exportParams(secrets, "k8s-secret", []string{"api"}, buf))
+
+		var object k8sObject
+		assert.Nil(t, yaml.Unmarshal(buf.Bytes(), &object))
+		assert.Equal(t, payload, object.Data["keystore"])
+	})
+
+	t.Run("Binary values should go to the binaryData of config maps", func(t *testing.T) {
+		buf := &bytes.Buffer{}
+		assert.Nil(t, exportParams(secrets, "k8s-configmap", []string{"api"}, buf))
+
+		var object k8sObject
+		assert.Nil(t, yaml.Unmarshal(buf.Bytes(), &object))
+		assert.Equal(t, map[string]string{"hostname": "db.example.com"}, object.Data)
+		assert.Equal(t, map[string]string{"keystore": payload}, object.BinaryData)
+	})
+}
+
+func TestExportInterpolated(t *testing.T) {
+	in, _ := newTestInterpolator(map[string]string{}, map[string]string{"REGION": "us-east-1"})
+	refs := []serviceRef{{Service: "shared"}, {Service: "api"}}
+	listed := []map[string]exportedSecret{
+		{"hosts": newExportedSecret("shared", "a.internal,b.internal", store.TypeStringList)},
+		{
+			"hosts":  newExportedSecret("api", "${ref:shared/hosts},c.${env:REGION}", store.TypeStringList),
+			"region": newExportedSecret("api", "${env:REGION}", store.TypeString),
+		},
+	}
+	assert.Nil(t, interpolateExportedSecrets(in, refs, listed))
+
+	buf := &bytes.Buffer{}
+	assert.Nil(t, exportParams(listed[1], "json", []string{"api"}, buf))
+	assert.JSONEq(t, `{"hosts": ["a.internal", "b.internal", "c.us-east-1"], "region": "us-east-1"}`, buf.String())
+}
+
+// fakeMetadataStore lists secrets with their metadata
+type fakeMetadataStore struct {
+	store.Store
+	secrets []store.Secret
+}
+
+func (s *fakeMetadataStore) List(service string, includeValues bool, includeTags bool) ([]store.Secret, error) {
+	return s.secrets, nil
+}
+
+func (s *fakeMetadataStore) ListRaw(service string) ([]store.RawSecret, error) {
+	rawSecrets := []store.RawSecret{}
+	for _, secret := range s.secrets {
+		rawSecrets = append(rawSecrets, store.RawSecret{Key: secret.Meta.Key, Value: *secret.Value, Type: secret.Meta.Type})
+	}
+	return rawSecrets, nil
+}
+
+func TestListExportedSecrets(t *testing.T) {
+	modified := time.Date(2018, 3, 4, 5, 6, 7, 0, time.FixedZone("PST", -8*3600))
+	secret := func(k, value, paramType string) store.Secret {
+		return store.Secret{
+			Value: &value,
+			Meta: store.SecretMetadata{
+				Key:       "/api/" + k,
+				Type:      paramType,
+				Version:   3,
+				CreatedBy: "arn:aws:iam::123456789012:user/alice",
+				Created:   modified,
+			},
+		}
+	}
+	secretStore := &fakeMetadataStore{secrets: []store.Secret{
+		secret("hostname", "db.example.com", store.TypeString),
+		secret("hosts", "a,b", store.TypeStringList),
+		secret("keystore", store.EncodeBinary([]byte{0xff}), store.TypeSecureString),
+	}}
+
+	for _, test := range []struct {
+		name     string
+		metadata bool
+		expected map[string]exportedSecret
+	}{
+		{
+			name: "Without metadata only values and types should be listed",
+			expected: map[string]exportedSecret{
+				"hostname": {Service: "api", Type: store.TypeString, Value: "db.example.com", raw: "db.example.com"},
+				"hosts":    {Service: "api", Type: store.TypeStringList, Value: []string{"a", "b"}, raw: "a,b"},
+				"keystore": {Service: "api", Type: store.TypeSecureString, Encoding: "base64", Value: "/w==", raw: "/w=="},
+			},
+		},
+		{
+			name:     "With metadata the version, modifier and UTC modification time should be listed",
+			metadata: true,
+			expected: map[string]exportedSecret{
+				"hostname": {Service: "api", Type: store.TypeString, Value: "db.example.com", Version: 3, ModifiedBy: "arn:aws:iam::123456789012:user/alice", Modified: modified.UTC(), raw: "db.example.com"},
+				"hosts":    {Service: "api", Type: store.TypeStringList, Value: []string{"a", "b"}, Version: 3, ModifiedBy: "arn:aws:iam::123456789012:user/alice", Modified: modified.UTC(), raw: "a,b"},
+				"keystore": {Service: "api", Type: store.TypeSecureString, Encoding: "base64", Value: "/w==", Version: 3, ModifiedBy: "arn:aws:iam::123456789012:user/alice", Modified: modified.UTC(), raw: "/w=="},
+			},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			exportMetadata = test.metadata
+			defer func() { exportMetadata = false }()
+
+			secrets, err := listExportedSecrets(secretStore, "api")
+			assert.Nil(t, err)
+			assert.Equal(t, test.expected, secrets)
+		})
+	}
+}
+
+func TestMergeExportedSecrets(t *testing.T) {
+	listed := []map[string]exportedSecret{
+		{
+			"a":   newExportedSecret("a", "a/a", store.TypeString),
+			"a_b": newExportedSecret("a", "a/a_b", store.TypeString),
+		},
+		{
+			"a":   newExportedSecret("a_b", "a_b/a", store.TypeString),
+			"b":   newExportedSecret("a_b", "a_b/b", store.TypeString),
+			"a_b": newExportedSecret("a_b", "a_b/a_b", store.TypeString),
+		},
+	}
+
+	for _, test := range []struct {
+		name     string
+		format   string
+		nested   bool
+		expected string
+	}{
+		{
+			name:     "Later services should override the keys of earlier ones",
+			format:   "json",
+			expected: `{"a":"a_b/a","a_b":"a_b/a_b","b":"a_b/b"}`,
+		},
+		{
+			name:     "Nested services should keep their keys apart",
+			format:   "json",
+			nested:   true,
+			expected: `{"a":{"a":"a/a","a_b":"a/a_b"},"a_b":{"a":"a_b/a","a_b":"a_b/a_b","b":"a_b/b"}}`,
+		},
+		{
+			name:     "Nested services should keep their keys apart in yaml",
+			format:   "yaml",
+			nested:   true,
+			expected: "a:\n  a: a/a\n  a_b: a/a_b\na_b:\n  a: a_b/a\n  a_b: a_b/a_b\n  b: a_b/b",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			secrets, nested := mergeExportedSecrets([]string{"a", "a_b"}, listed)
+
+			var v interface{}
+			if test.nested {
+				values := map[string]map[string]interface{}{}
+				for service, serviceSecrets := range nested {
+					values[service] = map[string]interface{}{}
+					for k, secret := range serviceSecrets {
+						values[service][k] = secret.Value
+					}
+				}
+				v = values
+			} else {
+				values := map[string]interface{}{}
+				for k, secret := range secrets {
+					values[k] = secret.Value
+				}
+				v = values
+			}
+
+			buf := &bytes.Buffer{}
+			assert.Nil(t, exportStructured(v, test.format, buf))
+			assert.Equal(t, test.expected, strings.TrimSuffix(buf.String(), "\n"))
+		})
+	}
+}
+
+func TestExportStructuredMetadata(t *testing.T) {
+	secret := newExportedSecret("api", "a,b", store.TypeStringList)
+	secret.Version = 2
+	secret.ModifiedBy = "alice"
+	secret.Modified = time.Date(2018, 3, 4, 5, 6, 7, 0, time.UTC)
+	binary := newExportedSecret("api", store.EncodeBinary([]byte("key")), store.TypeSecureString)
+	nested := map[string]map[string]exportedSecret{"api": {"hosts": secret, "keystore": binary}}
+
+	for _, test := range []struct {
+		format   string
+		expected string
+	}{
+		{
+			format:   "json",
+			expected: `{"api":{"hosts":{"service":"api","type":"StringList","value":["a","b"],"version":2,"modified_by":"alice","modified":"2018-03-04T05:06:07Z"},"keystore":{"service":"api","type":"SecureString","encoding":"base64","value":"a2V5","version":0,"modified_by":"","modified":"0001-01-01T00:00:00Z"}}}`,
+		},
+		{
+			format: "yaml",
+			expected: "api:\n  hosts:\n    service: api\n    type: StringList\n    value:\n    - a\n    - b\n    version: 2\n    modified_by: alice\n    modified: 2018-03-04T05:06:07Z\n" +
+				"  keystore:\n    service: api\n    type: SecureString\n    encoding: base64\n    value: a2V5\n    version: 0\n    modified_by: \"\"\n    modified: 0001-01-01T00:00:00Z",
+		},
+	} {
+		t.Run("Metadata should be exported as "+test.format, func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			assert.Nil(t, exportStructured(nested, test.format, buf))
+			assert.Equal(t, test.expected, strings.TrimSuffix(buf.String(), "\n"))
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package cmd
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/segmentio/chamber/store"
+)
+
+const (
+	// DefaultConcurrency is the default number of services fetched at once
+	DefaultConcurrency = 4
+
+	// throttledRetries is the number of times fetching a service is retried
+	// after SSM throttled it
+	throttledRetries = 3
+)
+
+var (
+	concurrency int
+
+	// throttledBackoff is the base delay before fetching a throttled service
+	// again
+	throttledBackoff = time.Second
+)
+
+// fetchServices calls fetch for every service, with at most --concurrency
+// calls running at once.  fetch is given the index of the service so that
+// results can be stored in the order of services, whatever the order fetches
+// complete in.
+//
+// Once SSM throttles a fetch, the remaining services are fetched one at a time
+// and the throttled ones are retried with a backoff.  The first error, in the
+// order of services, is returned.
+func fetchServices(services []string, fetch func(i int, service string) error) error {
+	workers := concurrency
+	if workers < 1 {
+		workers = 1
+	}
+	if workers > len(services) {
+		workers = len(services)
+	}
+
+	var (
+		wg        sync.WaitGroup
+		serial    sync.Mutex
+		mu        sync.Mutex
+		throttled bool
+	)
+	isThrottled := func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return throttled
+	}
+
+	errs := make([]error, len(services))
+	indexes := make(chan int)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				for attempt := 0; ; attempt++ {
+					slow := isThrottled()
+					if slow {
+						serial.Lock()
+					}
+					err := fetch(i, services[i])
+					if slow {
+						serial.Unlock()
+					}
+
+					if err == nil || !store.IsThrottled(errors.Cause(err)) || attempt == throttledRetries {
+						errs[i] = err
+						break
+					}
+					mu.Lock()
+					throttled = true
+					mu.Unlock()
+					time.Sleep(backoff(attempt))
+				}
+			}
+		}()
+	}
+	for i := range services {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// backoff returns how long to wait before the given retry attempt, doubling
+// with every attempt with up to 50% of jitter
+func backoff(attempt int) time.Duration {
+	delay := throttledBackoff << uint(attempt)
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
@@ -0,0 +1,78 @@
+package cmd
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFetchServices(t *testing.T) {
+	defer func(c int, b time.Duration) { concurrency, throttledBackoff = c, b }(concurrency, throttledBackoff)
+	concurrency = 3
+	throttledBackoff = time.Millisecond
+	services := []string{"a", "b", "c", "d", "e", "f"}
+
+	t.Run("Results should be in the order of services and concurrency bounded", func(t *testing.T) {
+		var mu sync.Mutex
+		running, maxRunning := 0, 0
+		results := make([]string, len(services))
+		err := fetchServices(services, func(i int, service string) error {
+			mu.Lock()
+			running++
+			if running > maxRunning {
+				maxRunning = running
+			}
+			mu.Unlock()
+
+			// Later services complete first
+			time.Sleep(time.Duration(len(services)-i) * time.Millisecond)
+			results[i] = service
+
+			mu.Lock()
+			running--
+			mu.Unlock()
+			return nil
+		})
+		assert.Nil(t, err)
+		assert.Equal(t, services, results)
+		assert.True(t, maxRunning <= concurrency)
+	})
+
+	t.Run("The first error in the order of services should be returned", func(t *testing.T) {
+		err := fetchServices(services, func(i int, service string) error {
+			if service == "b" || service == "e" {
+				return errors.New("failed " + service)
+			}
+			return nil
+		})
+		assert.EqualError(t, err, "failed b")
+	})
+
+	t.Run("Throttled services should be retried", func(t *testing.T) {
+		var mu sync.Mutex
+		attempts := map[string]int{}
+		err := fetchServices(services, func(i int, service string) error {
+			mu.Lock()
+			defer mu.Unlock()
+			attempts[service]++
+			if service == "c" && attempts[service] < 3 {
+				return awserr.New("ThrottlingException", "Rate exceeded", nil)
+			}
+			return nil
+		})
+		assert.Nil(t, err)
+		assert.Equal(t, 3, attempts["c"])
+		assert.Equal(t, 1, attempts["a"])
+	})
+
+	t.Run("Services throttled too many times should fail", func(t *testing.T) {
+		err := fetchServices(services[:1], func(i int, service string) error {
+			return awserr.New("ThrottlingException", "Rate exceeded", nil)
+		})
+		assert.NotNil(t, err)
+	})
+}
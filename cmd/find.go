@@ -0,0 +1,78 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var (
+	byValue bool
+
+	// findCmd represents the find command
+	findCmd = &cobra.Command{
+		Use:   "find <pattern>",
+		Short: "Find the services that hold a secret, searching across all services",
+		Args:  cobra.ExactArgs(1),
+		RunE:  find,
+	}
+)
+
+func init() {
+	findCmd.Flags().BoolVar(&byValue, "by-value", false, "Search for secrets whose value contains the pattern, instead of by key name")
+	RootCmd.AddCommand(findCmd)
+}
+
+func find(cmd *cobra.Command, args []string) error {
+	pattern := args[0]
+	if !byValue {
+		pattern = strings.ToLower(pattern)
+		if _, err := path.Match(pattern, ""); err != nil {
+			return errors.Wrap(err, "Failed to parse pattern")
+		}
+	}
+
+	secretStore := getSecretStore()
+	secrets, err := secretStore.ListAll("", byValue)
+	if err != nil {
+		return errors.Wrap(err, "Failed to list store contents")
+	}
+
+	matches := [][]string{}
+	for _, secret := range secrets {
+		k := key(secret.Meta.Key)
+		if byValue {
+			if secret.Value == nil || !strings.Contains(*secret.Value, pattern) {
+				continue
+			}
+		} else if matched, _ := path.Match(pattern, k); !matched {
+			continue
+		}
+		matches = append(matches, []string{keyService(secret.Meta.Key), k})
+	}
+
+	if len(matches) == 0 {
+		return errors.New("No matching secrets found")
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i][0] != matches[j][0] {
+			return matches[i][0] < matches[j][0]
+		}
+		return matches[i][1] < matches[j][1]
+	})
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, '\t', 0)
+	fmt.Fprintln(w, "Service\tKey")
+	for _, match := range matches {
+		fmt.Fprintf(w, "%s\t%s\n", match[0], match[1])
+	}
+	w.Flush()
+	return nil
+}
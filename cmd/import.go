@@ -22,6 +22,7 @@ var (
 )
 
 func init() {
+	importCmd.Flags().StringVar(&kmsKey, "kms-key", "", "KMS key to encrypt the securestring secrets with (default is the key configured for the service)")
 	RootCmd.AddCommand(importCmd)
 }
 
@@ -51,14 +52,16 @@ func importRun(cmd *cobra.Command, args []string) error {
 		return errors.Wrap(err, "Failed to decode input as json")
 	}
 
-	secretStore := store.NewSSMStore(numRetries)
-
+	secretStore := getSecretStore()
 	for key, value := range toBeImported {
 		secretId := store.SecretId{
 			Service: service,
 			Key:     key,
 		}
-		if err := secretStore.Write(secretId, value); err != nil {
+		// Secrets that already exist keep their type, and only SecureString
+		// secrets are encrypted with the key
+		opts := store.WriteOptions{DefaultKMSKey: kmsKeyFor(service)}
+		if err := secretStore.Write(secretId, value, opts); err != nil {
 			return errors.Wrap(err, "Failed to write secret")
 		}
 	}
@@ -0,0 +1,209 @@
+package cmd
+
+import (
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/segmentio/chamber/store"
+)
+
+// referencePattern matches the references in secret values: ${ref:<service>/<key>}
+// and ${env:<name>}.  A reference preceded by another $ is escaped.
+var referencePattern = regexp.MustCompile(`\$?\$\{(ref|env):([^}]*)\}`)
+
+// noInterpolate is the --no-interpolate flag of the commands that read
+// secrets, and interpolateEnv their --interpolate-env flag
+var (
+	noInterpolate  bool
+	interpolateEnv bool
+)
+
+// secretReader reads the latest value of a secret
+type secretReader interface {
+	Read(id store.SecretId, version int) (store.Secret, error)
+}
+
+// interpolator resolves the references in secret values.  Referenced secrets
+// are resolved recursively and only read once.
+type interpolator struct {
+	// read returns the latest value of a secret
+	read func(ref serviceRef, k string) (string, error)
+	// getenv looks up an environment variable.  It is nil unless
+	// environment references are resolved, which leaves them as is.
+	getenv func(name string) (string, bool)
+
+	// values are the values already fetched, and resolved the values whose
+	// references were resolved, by reference name
+	values   map[string]string
+	resolved map[string]string
+}
+
+// newInterpolator returns an interpolator reading the secrets of the default
+// location from defaultStore, and those of other accounts and regions from
+// their stores.  Environment references are only resolved when enabled with
+// --interpolate-env.
+func newInterpolator(defaultStore secretReader) *interpolator {
+	in := &interpolator{
+		read: func(ref serviceRef, k string) (string, error) {
+			s := defaultStore
+			if ref.location() != "" {
+				var err error
+				if s, err = locationStore(ref); err != nil {
+					return "", err
+				}
+			}
+			secret, err := s.Read(store.SecretId{Service: ref.Service, Key: k}, -1)
+			if err != nil {
+				return "", err
+			}
+			return *secret.Value, nil
+		},
+		values:   map[string]string{},
+		resolved: map[string]string{},
+	}
+	if envInterpolationEnabled() {
+		in.getenv = os.LookupEnv
+	}
+	return in
+}
+
+// interpolationEnabled returns whether references should be resolved, which
+// is disabled by --no-interpolate or $CHAMBER_NO_INTERPOLATE
+func interpolationEnabled() bool {
+	if _, disabled := os.LookupEnv("CHAMBER_NO_INTERPOLATE"); disabled {
+		return false
+	}
+	return !noInterpolate
+}
+
+// envInterpolationEnabled returns whether ${env:<name>} references should be
+// resolved, which they are only with --interpolate-env or
+// $CHAMBER_INTERPOLATE_ENV, so that values from the environment of whoever
+// runs chamber don't end up in exported secrets unless asked for
+func envInterpolationEnabled() bool {
+	if _, enabled := os.LookupEnv("CHAMBER_INTERPOLATE_ENV"); enabled {
+		return true
+	}
+	return interpolateEnv
+}
+
+// referenceName returns the name of the key of a service in references
+func referenceName(ref serviceRef, k string) string {
+	return ref.String() + "/" + k
+}
+
+// add records the value of a secret already fetched, so that references to it
+// do not read it again
+func (in *interpolator) add(ref serviceRef, k, value string) {
+	in.values[referenceName(ref, k)] = value
+}
+
+// interpolate returns value with its references resolved.  Binary values are
+// returned as is.
+func (in *interpolator) interpolate(ref serviceRef, k, value string) (string, error) {
+	if store.IsBinary(value) {
+		return value, nil
+	}
+	return in.expand(value, []string{referenceName(ref, k)})
+}
+
+// expand resolves the references in the value of the last secret of chain,
+// the secrets whose references are being resolved
+func (in *interpolator) expand(value string, chain []string) (string, error) {
+	var err error
+	expanded := referencePattern.ReplaceAllStringFunc(value, func(match string) string {
+		if err != nil {
+			return match
+		}
+		if strings.HasPrefix(match, "$$") {
+			return match[1:]
+		}
+		groups := referencePattern.FindStringSubmatch(match)
+		var resolved string
+		switch groups[1] {
+		case "env":
+			if in.getenv == nil {
+				return match
+			}
+			var ok bool
+			if resolved, ok = in.getenv(groups[2]); !ok {
+				err = errors.Errorf("Environment variable %s referenced by %s is not set", groups[2], chain[len(chain)-1])
+			}
+		case "ref":
+			resolved, err = in.reference(groups[2], chain)
+		}
+		return resolved
+	})
+	return expanded, err
+}
+
+// reference resolves a reference to a secret, given as
+// [<account>:][<region>/]<service>/<key>
+func (in *interpolator) reference(target string, chain []string) (string, error) {
+	referrer := chain[len(chain)-1]
+	i := strings.LastIndex(target, "/")
+	if i == -1 {
+		return "", errors.Errorf("Invalid reference %s in %s, expected <service>/<key>", target, referrer)
+	}
+	ref, err := parseServiceRef(target[:i])
+	if err != nil {
+		return "", errors.Wrapf(err, "Invalid reference %s in %s", target, referrer)
+	}
+	k := strings.ToLower(target[i+1:])
+	if err := validateKey(k); err != nil {
+		return "", errors.Wrapf(err, "Invalid reference %s in %s", target, referrer)
+	}
+
+	name := referenceName(ref, k)
+	for _, resolving := range chain {
+		if resolving == name {
+			return "", errors.Errorf("Reference cycle: %s", strings.Join(append(chain, name), " -> "))
+		}
+	}
+	if resolved, ok := in.resolved[name]; ok {
+		return resolved, nil
+	}
+
+	value, ok := in.values[name]
+	if !ok {
+		if value, err = in.read(ref, k); err != nil {
+			if err == store.ErrSecretNotFound {
+				return "", errors.Errorf("Secret %s referenced by %s was not found", name, referrer)
+			}
+			return "", errors.Wrapf(err, "Failed to read %s referenced by %s", name, referrer)
+		}
+		in.values[name] = value
+	}
+	if store.IsBinary(value) {
+		return "", errors.Errorf("Secret %s referenced by %s is binary", name, referrer)
+	}
+
+	resolved, err := in.expand(value, append(chain[:len(chain):len(chain)], name))
+	if err != nil {
+		return "", err
+	}
+	in.resolved[name] = resolved
+	return resolved, nil
+}
+
+// interpolateRawSecrets resolves the references in the values of the secrets
+// of services, listed in the order of refs
+func interpolateRawSecrets(in *interpolator, refs []serviceRef, serviceSecrets [][]store.RawSecret) error {
+	for i, rawSecrets := range serviceSecrets {
+		for _, rawSecret := range rawSecrets {
+			in.add(refs[i], key(rawSecret.Key), rawSecret.Value)
+		}
+	}
+	for i, rawSecrets := range serviceSecrets {
+		for j, rawSecret := range rawSecrets {
+			value, err := in.interpolate(refs[i], key(rawSecret.Key), rawSecret.Value)
+			if err != nil {
+				return err
+			}
+			serviceSecrets[i][j].Value = value
+		}
+	}
+	return nil
+}
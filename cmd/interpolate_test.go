@@ -0,0 +1,167 @@
+package cmd
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/segmentio/chamber/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestInterpolator(secrets map[string]string, env map[string]string) (*interpolator, *int) {
+	reads := 0
+	in := newInterpolator(nil)
+	in.read = func(ref serviceRef, k string) (string, error) {
+		reads++
+		value, ok := secrets[referenceName(ref, k)]
+		if !ok {
+			return "", store.ErrSecretNotFound
+		}
+		return value, nil
+	}
+	in.getenv = func(name string) (string, bool) {
+		value, ok := env[name]
+		return value, ok
+	}
+	return in, &reads
+}
+
+func TestInterpolate(t *testing.T) {
+	api := serviceRef{Service: "api"}
+	secrets := map[string]string{
+		"shared/db_host":             "db.internal",
+		"shared/db_url":              "postgres://${ref:shared/db_host}:${ref:shared/db_port}/app",
+		"shared/db_port":             "5432",
+		"prod:us-west-2/shared/host": "db.prod",
+	}
+	env := map[string]string{"HOSTNAME": "web-1"}
+
+	t.Run("Values without references are returned as is", func(t *testing.T) {
+		in, reads := newTestInterpolator(secrets, env)
+		for _, value := range []string{"", "plain", "${HOME}", "${other:x}", "$ref:x"} {
+			resolved, err := in.interpolate(api, "key", value)
+			assert.Nil(t, err)
+			assert.Equal(t, value, resolved)
+		}
+		assert.Equal(t, 0, *reads)
+	})
+
+	t.Run("References are resolved recursively and read once", func(t *testing.T) {
+		in, reads := newTestInterpolator(secrets, env)
+		resolved, err := in.interpolate(api, "database_url", "${ref:shared/db_url}?host=${env:HOSTNAME}")
+		assert.Nil(t, err)
+		assert.Equal(t, "postgres://db.internal:5432/app?host=web-1", resolved)
+
+		resolved, err = in.interpolate(api, "db_host", "${ref:Shared/DB_HOST}")
+		assert.Nil(t, err)
+		assert.Equal(t, "db.internal", resolved)
+		assert.Equal(t, 3, *reads)
+	})
+
+	t.Run("Values already fetched are not read", func(t *testing.T) {
+		in, reads := newTestInterpolator(secrets, env)
+		in.add(serviceRef{Service: "shared"}, "db_host", "db.local")
+		resolved, err := in.interpolate(api, "db_host", "${ref:shared/db_host}")
+		assert.Nil(t, err)
+		assert.Equal(t, "db.local", resolved)
+		assert.Equal(t, 0, *reads)
+	})
+
+	t.Run("References to other accounts and regions", func(t *testing.T) {
+		in, _ := newTestInterpolator(secrets, env)
+		resolved, err := in.interpolate(api, "db_host", "${ref:prod:us-west-2/shared/host}")
+		assert.Nil(t, err)
+		assert.Equal(t, "db.prod", resolved)
+	})
+
+	t.Run("Escaped references are not resolved", func(t *testing.T) {
+		in, reads := newTestInterpolator(secrets, env)
+		resolved, err := in.interpolate(api, "template", "$${ref:shared/db_host} $${env:HOSTNAME}")
+		assert.Nil(t, err)
+		assert.Equal(t, "${ref:shared/db_host} ${env:HOSTNAME}", resolved)
+		assert.Equal(t, 0, *reads)
+	})
+
+	t.Run("Binary values are not interpolated", func(t *testing.T) {
+		in, _ := newTestInterpolator(secrets, env)
+		value := store.BinaryValuePrefix + "JHtlbnY6SE9TVE5BTUV9"
+		resolved, err := in.interpolate(api, "blob", value)
+		assert.Nil(t, err)
+		assert.Equal(t, value, resolved)
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		cyclic := map[string]string{
+			"a/x": "${ref:b/y}",
+			"b/y": "${ref:a/x}",
+		}
+		in, _ := newTestInterpolator(cyclic, env)
+		_, err := in.interpolate(serviceRef{Service: "a"}, "x", cyclic["a/x"])
+		assert.EqualError(t, err, "Reference cycle: a/x -> b/y -> a/x")
+
+		in, _ = newTestInterpolator(cyclic, env)
+		_, err = in.interpolate(api, "key", "${ref:a/x}")
+		assert.EqualError(t, err, "Reference cycle: api/key -> a/x -> b/y -> a/x")
+
+		in, _ = newTestInterpolator(map[string]string{"shared/blob": store.BinaryValuePrefix + "AA=="}, env)
+		for value, message := range map[string]string{
+			"${ref:shared/missing}": "Secret shared/missing referenced by api/key was not found",
+			"${env:MISSING}":        "Environment variable MISSING referenced by api/key is not set",
+			"${ref:nokey}":          "Invalid reference nokey in api/key, expected <service>/<key>",
+			"${ref:shared/blob}":    "Secret shared/blob referenced by api/key is binary",
+		} {
+			_, err := in.interpolate(api, "key", value)
+			assert.EqualError(t, err, message)
+		}
+	})
+}
+
+func TestInterpolateRawSecrets(t *testing.T) {
+	in, reads := newTestInterpolator(map[string]string{}, map[string]string{})
+	refs := []serviceRef{{Service: "shared"}, {Service: "api"}}
+	serviceSecrets := [][]store.RawSecret{
+		{{Key: "/shared/db_host", Value: "db.internal"}},
+		{{Key: "/api/db_host", Value: "${ref:shared/db_host}"}},
+	}
+	assert.Nil(t, interpolateRawSecrets(in, refs, serviceSecrets))
+	assert.Equal(t, "db.internal", serviceSecrets[1][0].Value)
+	assert.Equal(t, 0, *reads)
+}
+
+func TestInterpolateEnvOptIn(t *testing.T) {
+	api := serviceRef{Service: "api"}
+	os.Setenv("CHAMBER_TEST_REGION", "us-east-1")
+	defer os.Unsetenv("CHAMBER_TEST_REGION")
+	defer func() { noInterpolate, interpolateEnv = false, false }()
+
+	t.Run("Environment references should be left as is by default", func(t *testing.T) {
+		resolved, err := newInterpolator(nil).interpolate(api, "region", "${env:CHAMBER_TEST_REGION}")
+		assert.Nil(t, err)
+		assert.Equal(t, "${env:CHAMBER_TEST_REGION}", resolved)
+	})
+
+	t.Run("Environment references should be resolved with --interpolate-env", func(t *testing.T) {
+		interpolateEnv = true
+		defer func() { interpolateEnv = false }()
+
+		resolved, err := newInterpolator(nil).interpolate(api, "region", "${env:CHAMBER_TEST_REGION}")
+		assert.Nil(t, err)
+		assert.Equal(t, "us-east-1", resolved)
+	})
+
+	t.Run("--no-interpolate should leave environment references in exported values", func(t *testing.T) {
+		interpolateEnv = true
+		defer func() { interpolateEnv = false }()
+		assert.Nil(t, exportCmd.Flags().Parse([]string{"--no-interpolate"}))
+		assert.False(t, interpolationEnabled())
+
+		secretStore := &fakeMetadataStore{secrets: []store.Secret{newTypedSecret(store.SecretId{Service: "api", Key: "region"}, "${env:CHAMBER_TEST_REGION}", store.TypeString, "")}}
+		listed, err := listExportedSecrets(secretStore, "api")
+		assert.Nil(t, err)
+
+		buf := &bytes.Buffer{}
+		assert.Nil(t, exportParams(listed, "dotenv", []string{"api"}, buf))
+		assert.Equal(t, "REGION='${env:CHAMBER_TEST_REGION}'\n", buf.String())
+	})
+}
@@ -20,11 +20,15 @@ var listCmd = &cobra.Command{
 }
 
 var (
-	withValues bool
+	withValues   bool
+	withTags     bool
+	filterByTags []string
 )
 
 func init() {
 	listCmd.Flags().BoolVarP(&withValues, "expand", "e", false, "Expand parameter list with values")
+	listCmd.Flags().BoolVarP(&withTags, "describe", "d", false, "Expand parameter list with descriptions and tags")
+	listCmd.Flags().StringArrayVar(&filterByTags, "tag", []string{}, "Only list secrets with the given tag, as key=value (can be repeated)")
 	RootCmd.AddCommand(listCmd)
 }
 
@@ -34,29 +38,50 @@ func list(cmd *cobra.Command, args []string) error {
 		return errors.Wrap(err, "Failed to validate service")
 	}
 
-	secretStore := store.NewSSMStore(numRetries)
-	secrets, err := secretStore.List(service, withValues)
+	tagFilter, err := parseTags(filterByTags)
+	if err != nil {
+		return errors.Wrap(err, "Failed to parse tag filter")
+	}
+
+	secretStore := getSecretStore()
+	secrets, err := secretStore.List(service, withValues, withTags || len(tagFilter) > 0)
 	if err != nil {
 		return errors.Wrap(err, "Failed to list store contents")
 	}
+	if err := checkTagsRead(secrets); err != nil {
+		if len(tagFilter) > 0 {
+			return errors.Wrap(err, "Failed to filter secrets by tags")
+		}
+		fmt.Fprintln(os.Stderr, "warning: descriptions and tags are missing, reading them requires the ssm:ListTagsForResource permission")
+	}
 
 	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, '\t', 0)
 
-	fmt.Fprint(w, "Key\tVersion\tLastModified\tUser")
+	fmt.Fprint(w, "Key\tVersion\tType\tLastModified\tUser")
 	if withValues {
 		fmt.Fprint(w, "\tValue")
 	}
+	if withTags {
+		fmt.Fprint(w, "\tDescription\tTags")
+	}
 	fmt.Fprintln(w, "")
 
 	for _, secret := range secrets {
-		fmt.Fprintf(w, "%s\t%d\t%s\t%s",
+		if !matchTags(secret.Meta.Tags, tagFilter) {
+			continue
+		}
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s",
 			key(secret.Meta.Key),
 			secret.Meta.Version,
+			secret.Meta.Type,
 			secret.Meta.Created.Local().Format(ShortTimeFormat),
 			secret.Meta.CreatedBy)
 		if withValues {
 			fmt.Fprintf(w, "\t%s", *secret.Value)
 		}
+		if withTags {
+			fmt.Fprintf(w, "\t%s\t%s", secret.Meta.Description, formatTags(secret.Meta.Tags))
+		}
 		fmt.Fprintln(w, "")
 	}
 
@@ -64,9 +89,29 @@ func list(cmd *cobra.Command, args []string) error {
 	return nil
 }
 
+// checkTagsRead fails if the description and tags of any of secrets could not
+// be read, for commands which would lose them otherwise
+func checkTagsRead(secrets []store.Secret) error {
+	for _, secret := range secrets {
+		if secret.Meta.TagsDenied {
+			return errors.New("Failed to read descriptions and tags, which requires the ssm:ListTagsForResource permission")
+		}
+	}
+	return nil
+}
+
+// matchTags returns whether tags contains every key=value pair in filter
+func matchTags(tags map[string]string, filter map[string]string) bool {
+	for k, v := range filter {
+		if tagValue, ok := tags[k]; !ok || tagValue != v {
+			return false
+		}
+	}
+	return true
+}
+
 func key(s string) string {
-	_, noPaths := os.LookupEnv("CHAMBER_NO_PATHS")
-	if !noPaths {
+	if !noPaths() {
 		tokens := strings.Split(s, "/")
 		secretKey := tokens[2]
 		return secretKey
@@ -76,3 +121,13 @@ func key(s string) string {
 	secretKey := tokens[1]
 	return secretKey
 }
+
+func keyService(s string) string {
+	if !noPaths() {
+		tokens := strings.Split(s, "/")
+		return tokens[1]
+	}
+
+	tokens := strings.Split(s, ".")
+	return tokens[0]
+}
@@ -0,0 +1,54 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var (
+	servicePrefix string
+
+	// listServicesCmd represents the list-services command
+	listServicesCmd = &cobra.Command{
+		Use:   "list-services",
+		Short: "List the services present in the store",
+		Args:  cobra.NoArgs,
+		RunE:  listServices,
+	}
+)
+
+func init() {
+	listServicesCmd.Flags().StringVarP(&servicePrefix, "prefix", "p", "", "Only list services whose name begins with the prefix")
+	RootCmd.AddCommand(listServicesCmd)
+}
+
+func listServices(cmd *cobra.Command, args []string) error {
+	servicePrefix = strings.ToLower(servicePrefix)
+	if servicePrefix != "" {
+		if err := validateService(servicePrefix); err != nil {
+			return errors.Wrap(err, "Failed to validate service prefix")
+		}
+	}
+
+	secretStore := getSecretStore()
+	services, err := secretStore.ListServices(servicePrefix)
+	if err != nil {
+		return errors.Wrap(err, "Failed to list services")
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, '\t', 0)
+	fmt.Fprintln(w, "Service\tKeys\tLastModified")
+	for _, service := range services {
+		fmt.Fprintf(w, "%s\t%d\t%s\n",
+			service.Service,
+			service.Keys,
+			service.LastModified.Local().Format(ShortTimeFormat))
+	}
+	w.Flush()
+	return nil
+}
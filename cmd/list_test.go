@@ -0,0 +1,17 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/segmentio/chamber/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckTagsRead(t *testing.T) {
+	tagged := store.Secret{Meta: store.SecretMetadata{Tags: map[string]string{"owner": "payments"}}}
+	denied := store.Secret{Meta: store.SecretMetadata{Tags: map[string]string{}, TagsDenied: true}}
+
+	assert.Nil(t, checkTagsRead([]store.Secret{}))
+	assert.Nil(t, checkTagsRead([]store.Secret{tagged}))
+	assert.NotNil(t, checkTagsRead([]store.Secret{tagged, denied}))
+}
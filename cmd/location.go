@@ -0,0 +1,134 @@
+package cmd
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/segmentio/chamber/store"
+)
+
+// serviceRef is a service, optionally in another AWS account or region than
+// the one chamber is configured with, given as
+// [<account>:][<region>/]<service>
+type serviceRef struct {
+	Account string
+	Region  string
+	Service string
+}
+
+// accountSettings select the credentials and region of an account that
+// services can be read from, in the accounts section of the config file
+type accountSettings struct {
+	Profile    string `yaml:"profile"`
+	RoleARN    string `yaml:"role_arn"`
+	ExternalID string `yaml:"external_id"`
+	MFASerial  string `yaml:"mfa_serial"`
+	Region     string `yaml:"region"`
+}
+
+var (
+	locationStoresMu sync.Mutex
+	locationStores   = map[string]store.Store{}
+)
+
+// parseServiceRef parses a service reference.  Service names are lower
+// cased.
+func parseServiceRef(s string) (serviceRef, error) {
+	var ref serviceRef
+	rest := s
+	if i := strings.Index(rest, ":"); i != -1 {
+		ref.Account, rest = rest[:i], rest[i+1:]
+		if ref.Account == "" {
+			return ref, errors.Errorf("Failed to parse service %s, the account is empty", s)
+		}
+	}
+	if i := strings.Index(rest, "/"); i != -1 {
+		ref.Region, rest = rest[:i], rest[i+1:]
+		if ref.Region == "" {
+			return ref, errors.Errorf("Failed to parse service %s, the region is empty", s)
+		}
+	}
+	ref.Service = strings.ToLower(rest)
+	if err := validateService(ref.Service); err != nil {
+		return ref, err
+	}
+	return ref, nil
+}
+
+// parseServiceRefs parses service references, failing on the first invalid
+// one
+func parseServiceRefs(services []string) ([]serviceRef, error) {
+	refs := make([]serviceRef, len(services))
+	for i, service := range services {
+		ref, err := parseServiceRef(service)
+		if err != nil {
+			return nil, errors.Wrap(err, "Failed to validate service")
+		}
+		refs[i] = ref
+	}
+	return refs, nil
+}
+
+// String returns the reference in the format parsed by parseServiceRef
+func (r serviceRef) String() string {
+	return r.location() + r.Service
+}
+
+// location returns the account and region of the reference, the empty string
+// if it is a service of the default location
+func (r serviceRef) location() string {
+	location := ""
+	if r.Account != "" {
+		location += r.Account + ":"
+	}
+	if r.Region != "" {
+		location += r.Region + "/"
+	}
+	return location
+}
+
+// locationStore returns the store of the account and region of ref, which
+// must not be the default location.  Stores are created once per location,
+// with the credentials of the account in the config file, or the AWS profile
+// named after the account.
+func locationStore(ref serviceRef) (store.Store, error) {
+	location := ref.location()
+	locationStoresMu.Lock()
+	defer locationStoresMu.Unlock()
+	if s, ok := locationStores[location]; ok {
+		return s, nil
+	}
+
+	auth := authOptions()
+	if ref.Account != "" {
+		account, ok := config.Accounts[ref.Account]
+		if !ok {
+			account = accountSettings{Profile: ref.Account}
+		}
+		auth.Profile = account.Profile
+		auth.RoleARN = account.RoleARN
+		auth.ExternalID = account.ExternalID
+		auth.MFASerial = account.MFASerial
+		auth.TokenProvider = mfaCodeReader(account.MFASerial)
+		if account.Region != "" {
+			auth.Region = account.Region
+		}
+	}
+	if ref.Region != "" {
+		auth.Region = ref.Region
+	}
+
+	logger.Log(store.LogInfo, "setting up store", map[string]interface{}{
+		"location": location,
+		"profile":  auth.Profile,
+		"role_arn": auth.RoleARN,
+		"region":   auth.Region,
+	})
+	s, err := newSSMStore(auth)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to set up the store of %s", strings.TrimRight(location, ":/"))
+	}
+	locationStores[location] = s
+	return s, nil
+}
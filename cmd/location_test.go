@@ -0,0 +1,37 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseServiceRef(t *testing.T) {
+	for s, expected := range map[string]serviceRef{
+		"api":                        {Service: "api"},
+		"API":                        {Service: "api"},
+		"shared:datadog":             {Account: "shared", Service: "datadog"},
+		"us-west-2/api":              {Region: "us-west-2", Service: "api"},
+		"prod-account:us-west-2/api": {Account: "prod-account", Region: "us-west-2", Service: "api"},
+	} {
+		ref, err := parseServiceRef(s)
+		assert.Nil(t, err, s)
+		assert.Equal(t, expected, ref, s)
+	}
+
+	for _, s := range []string{":api", "shared:/api", "shared:us-east-1/", "a:b:c", "a/b/c"} {
+		_, err := parseServiceRef(s)
+		assert.NotNil(t, err, s)
+	}
+
+	t.Run("References should be formatted as they are parsed", func(t *testing.T) {
+		ref, err := parseServiceRef("shared:us-east-1/datadog")
+		assert.Nil(t, err)
+		assert.Equal(t, "shared:us-east-1/datadog", ref.String())
+		assert.Equal(t, "shared:us-east-1/", ref.location())
+
+		ref, err = parseServiceRef("api")
+		assert.Nil(t, err)
+		assert.Equal(t, "", ref.location())
+	})
+}
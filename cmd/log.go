@@ -0,0 +1,107 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/segmentio/chamber/store"
+)
+
+var (
+	debug     bool
+	logFormat string
+
+	// logger logs what chamber does with --verbose and --debug, and
+	// discards everything otherwise
+	logger store.Logger = &textLogger{w: ioutil.Discard}
+)
+
+// setupLogging sets up the logger selected by --verbose, --debug and
+// --log-format, for chamber and its stores
+func setupLogging() error {
+	if !verbose && !debug {
+		return nil
+	}
+
+	level := store.LogInfo
+	if debug {
+		level = store.LogDebug
+	}
+	switch logFormat {
+	case "text":
+		logger = &textLogger{w: os.Stderr, level: level}
+	case "json":
+		logger = &jsonLogger{w: os.Stderr, level: level}
+	default:
+		return errors.Errorf("Unsupported log format %s, use text or json", logFormat)
+	}
+	store.SetLogger(logger)
+	return nil
+}
+
+func logLevelName(level store.LogLevel) string {
+	if level == store.LogDebug {
+		return "debug"
+	}
+	return "info"
+}
+
+// textLogger writes log entries as lines of key=value pairs
+type textLogger struct {
+	mu    sync.Mutex
+	w     io.Writer
+	level store.LogLevel
+}
+
+func (l *textLogger) Log(level store.LogLevel, msg string, fields map[string]interface{}) {
+	if level > l.level {
+		return
+	}
+
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	line := fmt.Sprintf("%s [%s] %s", time.Now().Format(ShortTimeFormat), logLevelName(level), msg)
+	for _, k := range keys {
+		line += fmt.Sprintf(" %s=%v", k, fields[k])
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	fmt.Fprintln(l.w, line)
+}
+
+// jsonLogger writes log entries as JSON objects, one per line
+type jsonLogger struct {
+	mu    sync.Mutex
+	w     io.Writer
+	level store.LogLevel
+}
+
+func (l *jsonLogger) Log(level store.LogLevel, msg string, fields map[string]interface{}) {
+	if level > l.level {
+		return
+	}
+
+	entry := make(map[string]interface{}, len(fields)+3)
+	for k, v := range fields {
+		entry[k] = v
+	}
+	entry["time"] = time.Now().UTC().Format(time.RFC3339Nano)
+	entry["level"] = logLevelName(level)
+	entry["msg"] = msg
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	json.NewEncoder(l.w).Encode(entry)
+}
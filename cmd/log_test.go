@@ -0,0 +1,42 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/segmentio/chamber/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoggers(t *testing.T) {
+	fields := map[string]interface{}{"name": "/api/key", "pages": 2}
+
+	t.Run("Text logs should list sorted fields", func(t *testing.T) {
+		buf := &bytes.Buffer{}
+		l := &textLogger{w: buf, level: store.LogDebug}
+		l.Log(store.LogDebug, "ssm request", fields)
+		assert.True(t, strings.HasSuffix(buf.String(), "[debug] ssm request name=/api/key pages=2\n"))
+	})
+
+	t.Run("JSON logs should hold the fields", func(t *testing.T) {
+		buf := &bytes.Buffer{}
+		l := &jsonLogger{w: buf, level: store.LogInfo}
+		l.Log(store.LogInfo, "resolved region", fields)
+
+		var entry map[string]interface{}
+		assert.Nil(t, json.Unmarshal(buf.Bytes(), &entry))
+		assert.Equal(t, "info", entry["level"])
+		assert.Equal(t, "resolved region", entry["msg"])
+		assert.Equal(t, "/api/key", entry["name"])
+		assert.Equal(t, float64(2), entry["pages"])
+	})
+
+	t.Run("Debug entries should be dropped unless debugging", func(t *testing.T) {
+		buf := &bytes.Buffer{}
+		l := &jsonLogger{w: buf, level: store.LogInfo}
+		l.Log(store.LogDebug, "ssm request", fields)
+		assert.Equal(t, "", buf.String())
+	})
+}
@@ -0,0 +1,104 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/segmentio/chamber/store"
+	"github.com/spf13/cobra"
+)
+
+// mvCmd represents the mv command
+var mvCmd = &cobra.Command{
+	Use:   "mv <service> <old-key> <new-key> | mv <service>/<key> <other-service>/<key>",
+	Short: "Rename a secret or move it to another service",
+	Args:  cobra.RangeArgs(2, 3),
+	RunE:  mv,
+}
+
+func init() {
+	RootCmd.AddCommand(mvCmd)
+}
+
+func mv(cmd *cobra.Command, args []string) error {
+	var from, to store.SecretId
+	var err error
+	if len(args) == 3 {
+		from = store.SecretId{Service: args[0], Key: args[1]}
+		to = store.SecretId{Service: args[0], Key: args[2]}
+	} else {
+		if from, err = parseSecretId(args[0]); err != nil {
+			return err
+		}
+		if to, err = parseSecretId(args[1]); err != nil {
+			return err
+		}
+	}
+
+	for _, id := range []*store.SecretId{&from, &to} {
+		id.Service = strings.ToLower(id.Service)
+		if err := validateService(id.Service); err != nil {
+			return errors.Wrap(err, "Failed to validate service")
+		}
+		id.Key = strings.ToLower(id.Key)
+		if err := validateKey(id.Key); err != nil {
+			return errors.Wrap(err, "Failed to validate key")
+		}
+	}
+	if from == to {
+		return errors.New("Source and destination are the same secret")
+	}
+
+	return moveSecret(getSecretStore(), from, to)
+}
+
+// moveSecret writes the latest value of a secret to a new one with the same
+// type, description and tags, and soft deletes the old one
+func moveSecret(secretStore store.Store, from, to store.SecretId) error {
+	secret, err := secretStore.Read(from, -1)
+	if err != nil {
+		return errors.Wrap(err, "Failed to read")
+	}
+	if err := checkTagsRead([]store.Secret{secret}); err != nil {
+		return errors.Wrap(err, "Failed to move")
+	}
+
+	if _, err := secretStore.Read(to, -1); err == nil {
+		return fmt.Errorf("Secret %s/%s already exists", to.Service, to.Key)
+	} else if err != store.ErrSecretNotFound {
+		return errors.Wrap(err, "Failed to read destination")
+	}
+
+	tags := map[string]string{}
+	for k, v := range secret.Meta.Tags {
+		tags[k] = v
+	}
+	tags[store.MovedFromTagKey] = fmt.Sprintf("%s/%s@%d", from.Service, from.Key, secret.Meta.Version)
+
+	paramType := writtenType(secret.Meta.Type, store.Secret{})
+	opts := store.WriteOptions{
+		Description: secret.Meta.Description,
+		Tags:        tags,
+		KMSKey:      kmsKeyForType(to.Service, paramType),
+		Type:        paramType,
+	}
+	if err := secretStore.Write(to, *secret.Value, opts); err != nil {
+		return errors.Wrap(err, "Failed to write")
+	}
+
+	// Soft delete, so the history of the old secret can still be recovered
+	if err := secretStore.SoftDelete(from); err != nil {
+		return errors.Wrap(err, "Failed to delete the old secret")
+	}
+	return nil
+}
+
+// parseSecretId parses a secret given as <service>/<key>
+func parseSecretId(s string) (store.SecretId, error) {
+	parts := strings.Split(s, "/")
+	if len(parts) != 2 {
+		return store.SecretId{}, fmt.Errorf("Failed to parse '%s'.  Secrets must be given as <service>/<key>", s)
+	}
+	return store.SecretId{Service: parts[0], Key: parts[1]}, nil
+}
@@ -0,0 +1,112 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/segmentio/chamber/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMoveSecret(t *testing.T) {
+	defer func() { config = configSettings{} }()
+	config.KMSKeys = []kmsKeyMapping{{Pattern: "pci-*", Key: "pci_key"}}
+
+	for _, test := range []struct {
+		name         string
+		paramType    string
+		expectedType string
+		expectedKey  string
+	}{
+		{
+			name:         "String secrets should stay unencrypted under a mapped key",
+			paramType:    store.TypeString,
+			expectedType: store.TypeString,
+		},
+		{
+			name:         "StringList secrets should stay unencrypted under a mapped key",
+			paramType:    store.TypeStringList,
+			expectedType: store.TypeStringList,
+		},
+		{
+			name:         "SecureString secrets should be encrypted with the mapped key",
+			paramType:    store.TypeSecureString,
+			expectedType: store.TypeSecureString,
+			expectedKey:  "pci_key",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			from := store.SecretId{Service: "api", Key: "hosts"}
+			to := store.SecretId{Service: "pci-api", Key: "hosts"}
+			secretStore := &fakeTypedStore{secrets: map[store.SecretId]store.Secret{
+				from: newTypedSecret(from, "a,b", test.paramType, ""),
+			}}
+
+			assert.Nil(t, moveSecret(secretStore, from, to))
+
+			assert.Len(t, secretStore.writes, 1)
+			assert.Equal(t, test.expectedType, secretStore.writes[0].opts.Type)
+			assert.Equal(t, test.expectedKey, secretStore.writes[0].opts.KMSKey)
+			assert.Equal(t, test.expectedType, secretStore.secrets[to].Meta.Type)
+			_, stillThere := secretStore.secrets[from]
+			assert.False(t, stillThere)
+		})
+	}
+}
+
+func TestMoveSecretMetadata(t *testing.T) {
+	from := store.SecretId{Service: "api", Key: "db_password"}
+	to := store.SecretId{Service: "billing", Key: "db_password"}
+	newStore := func() *fakeTypedStore {
+		secret := newTypedSecret(from, "hunter2", store.TypeSecureString, "alias/parameter_store_key")
+		secret.Meta.Version = 3
+		secret.Meta.Description = "Primary database password"
+		secret.Meta.Tags = map[string]string{"owner": "payments"}
+		return &fakeTypedStore{secrets: map[store.SecretId]store.Secret{from: secret}}
+	}
+
+	t.Run("Existing destinations should not be overwritten", func(t *testing.T) {
+		secretStore := newStore()
+		secretStore.secrets[to] = newTypedSecret(to, "other", store.TypeSecureString, "")
+
+		assert.NotNil(t, moveSecret(secretStore, from, to))
+		assert.Equal(t, 0, len(secretStore.writes))
+		assert.Equal(t, "hunter2", *secretStore.secrets[from].Value)
+		assert.Equal(t, "other", *secretStore.secrets[to].Value)
+	})
+
+	t.Run("The description and tags should be kept, and the origin tagged", func(t *testing.T) {
+		secretStore := newStore()
+		assert.Nil(t, moveSecret(secretStore, from, to))
+
+		assert.Equal(t, 1, len(secretStore.writes))
+		write := secretStore.writes[0]
+		assert.Equal(t, to, write.id)
+		assert.Equal(t, "hunter2", write.value)
+		assert.Equal(t, "Primary database password", write.opts.Description)
+		assert.Equal(t, store.TypeSecureString, write.opts.Type)
+		assert.Equal(t, map[string]string{
+			"owner":               "payments",
+			store.MovedFromTagKey: "api/db_password@3",
+		}, write.opts.Tags)
+	})
+
+	t.Run("Secrets whose tags can't be read should not be moved", func(t *testing.T) {
+		secretStore := newStore()
+		secret := secretStore.secrets[from]
+		secret.Meta.Description = ""
+		secret.Meta.Tags = map[string]string{}
+		secret.Meta.TagsDenied = true
+		secretStore.secrets[from] = secret
+
+		assert.NotNil(t, moveSecret(secretStore, from, to))
+		assert.Equal(t, 0, len(secretStore.writes))
+		_, stillThere := secretStore.secrets[from]
+		assert.True(t, stillThere)
+	})
+
+	t.Run("Missing sources should be reported", func(t *testing.T) {
+		secretStore := newStore()
+		assert.NotNil(t, moveSecret(secretStore, to, from))
+		assert.Equal(t, 0, len(secretStore.writes))
+	})
+}
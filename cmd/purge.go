@@ -0,0 +1,78 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/segmentio/chamber/store"
+	"github.com/spf13/cobra"
+)
+
+var (
+	olderThan time.Duration
+
+	// purgeCmd represents the purge command
+	purgeCmd = &cobra.Command{
+		Use:   "purge --older-than <duration>",
+		Short: "Permanently remove deleted secrets from the recycle bin",
+		Args:  cobra.NoArgs,
+		RunE:  purge,
+	}
+)
+
+func init() {
+	purgeCmd.Flags().DurationVar(&olderThan, "older-than", 30*24*time.Hour, "Only purge secrets deleted longer ago than this (e.g. 720h)")
+	purgeCmd.Flags().BoolVar(&confirmed, "confirm", false, "Do not ask for confirmation before purging")
+	purgeCmd.Flags().BoolVar(&dryRun, "dry-run", false, "Only print the secrets that would be purged")
+	RootCmd.AddCommand(purgeCmd)
+}
+
+func purge(cmd *cobra.Command, args []string) error {
+	secretStore := getSecretStore()
+	deleted, err := secretStore.ListDeleted()
+	if err != nil {
+		return errors.Wrap(err, "Failed to list the recycle bin")
+	}
+
+	cutoff := time.Now().Add(-olderThan)
+	toPurge := []store.DeletedSecret{}
+	for _, d := range deleted {
+		if d.DeletedAt.Before(cutoff) {
+			toPurge = append(toPurge, d)
+		}
+	}
+	if len(toPurge) == 0 {
+		fmt.Fprintln(os.Stdout, "Nothing to purge")
+		return nil
+	}
+
+	fmt.Fprintf(os.Stdout, "The following %d deleted secrets will be permanently removed:\n", len(toPurge))
+	for _, d := range toPurge {
+		fmt.Fprintf(os.Stdout, "  %s/%s (deleted %s)\n", d.Id.Service, d.Id.Key, d.DeletedAt.Local().Format(ShortTimeFormat))
+	}
+
+	if dryRun {
+		return nil
+	}
+
+	if !confirmed {
+		ok, err := promptConfirm(fmt.Sprintf("Permanently remove %d deleted secrets?", len(toPurge)))
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return errors.New("Aborted")
+		}
+	}
+
+	for _, d := range toPurge {
+		if err := secretStore.Purge(d); err != nil {
+			return errors.Wrapf(err, "Failed to purge %s/%s", d.Id.Service, d.Id.Key)
+		}
+	}
+
+	fmt.Fprintf(os.Stdout, "Successfully purged %d deleted secrets\n", len(toPurge))
+	return nil
+}
@@ -27,6 +27,8 @@ var (
 func init() {
 	readCmd.Flags().IntVarP(&version, "version", "v", -1, "The version number of the secret. Defaults to latest.")
 	readCmd.Flags().BoolVarP(&quiet, "quiet", "q", false, "Only print the secret")
+	readCmd.Flags().BoolVar(&noInterpolate, "no-interpolate", false, "Do not resolve ${ref:<service>/<key>} and ${env:<name>} references in the value")
+	readCmd.Flags().BoolVar(&interpolateEnv, "interpolate-env", false, "Also resolve ${env:<name>} references in the value, from the environment chamber runs in (default is to leave them as is)")
 	RootCmd.AddCommand(readCmd)
 }
 
@@ -41,7 +43,14 @@ func read(cmd *cobra.Command, args []string) error {
 		return errors.Wrap(err, "Failed to validate key")
 	}
 
-	secretStore := store.NewSSMStore(numRetries)
+	// The agent only has the latest values of secrets, without the metadata
+	// printed along with them
+	var secretStore secretReader
+	if quiet {
+		secretStore = newAgentStore()
+	} else {
+		secretStore = getSecretStore()
+	}
 	secretId := store.SecretId{
 		Service: service,
 		Key:     key,
@@ -51,20 +60,42 @@ func read(cmd *cobra.Command, args []string) error {
 	if err != nil {
 		return errors.Wrap(err, "Failed to read")
 	}
+	if interpolationEnabled() {
+		ref := serviceRef{Service: service}
+		value, err := newInterpolator(secretStore).interpolate(ref, key, *secret.Value)
+		if err != nil {
+			return err
+		}
+		secret.Value = &value
+	}
 
-	if quiet {
+	if store.IsBinary(*secret.Value) {
+		data, err := store.DecodeValue(*secret.Value)
+		if err != nil {
+			return errors.Wrap(err, "Failed to decode binary value")
+		}
+		if quiet {
+			// Binary content is written as is, without a trailing newline
+			_, err := os.Stdout.Write(data)
+			return err
+		}
+		value := fmt.Sprintf("<binary, %d bytes>", len(data))
+		secret.Value = &value
+	} else if quiet {
 		fmt.Fprintf(os.Stdout, "%s\n", *secret.Value)
 		return nil
 	}
 
 	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, '\t', 0)
-	fmt.Fprintln(w, "Key\tValue\tVersion\tLastModified\tUser")
-	fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\n",
+	fmt.Fprintln(w, "Key\tValue\tVersion\tLastModified\tUser\tDescription\tTags")
+	fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\t%s\t%s\n",
 		key,
 		*secret.Value,
 		secret.Meta.Version,
 		secret.Meta.Created.Local().Format(ShortTimeFormat),
-		secret.Meta.CreatedBy)
+		secret.Meta.CreatedBy,
+		secret.Meta.Description,
+		formatTags(secret.Meta.Tags))
 	w.Flush()
 	return nil
 }
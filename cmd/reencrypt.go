@@ -0,0 +1,83 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/segmentio/chamber/store"
+	"github.com/spf13/cobra"
+)
+
+// reencryptCmd represents the reencrypt command
+var reencryptCmd = &cobra.Command{
+	Use:   "reencrypt <service> [--kms-key <key>]",
+	Short: "Encrypt the secrets of a service with another KMS key",
+	Long: `Encrypt the secrets of a service with another KMS key.
+
+The latest value of every securestring secret of the service that is not
+already encrypted with the key is written again as a new version, encrypted
+with the key given with --kms-key, or the key configured for the service.
+Older versions remain encrypted with the key they were written with, and
+string and stringlist secrets, which are not encrypted, are skipped.`,
+	Args: cobra.ExactArgs(1),
+	RunE: reencrypt,
+}
+
+func init() {
+	reencryptCmd.Flags().StringVar(&kmsKey, "kms-key", "", "KMS key to encrypt the secrets with (default is the key configured for the service)")
+	reencryptCmd.Flags().BoolVar(&dryRun, "dry-run", false, "Only print the secrets that would be encrypted again")
+	RootCmd.AddCommand(reencryptCmd)
+}
+
+func reencrypt(cmd *cobra.Command, args []string) error {
+	service := strings.ToLower(args[0])
+	if err := validateService(service); err != nil {
+		return errors.Wrap(err, "Failed to validate service")
+	}
+
+	target := kmsKeyFor(service)
+	if target == "" {
+		return fmt.Errorf("No KMS key is configured for service %s, give one with --kms-key", service)
+	}
+	return reencryptService(getSecretStore(), service, store.KMSKeyID(target))
+}
+
+// reencryptService writes the latest value of every SecureString secret of
+// service that is not encrypted with keyID again, encrypted with keyID.
+// Secrets of other types are not encrypted and are skipped.
+func reencryptService(secretStore store.Store, service, keyID string) error {
+	secrets, err := secretStore.List(service, true, false)
+	if err != nil {
+		return errors.Wrap(err, "Failed to list store contents")
+	}
+	sort.Slice(secrets, func(i, j int) bool { return secrets[i].Meta.Key < secrets[j].Meta.Key })
+
+	count := 0
+	for _, secret := range secrets {
+		k := key(secret.Meta.Key)
+		if secret.Meta.Type != store.TypeSecureString || secret.Meta.KMSKey == keyID {
+			continue
+		}
+		if dryRun {
+			fmt.Fprintf(os.Stdout, "Would encrypt %s/%s with %s (currently %s)\n", service, k, keyID, secret.Meta.KMSKey)
+			continue
+		}
+
+		secretId := store.SecretId{
+			Service: service,
+			Key:     k,
+		}
+		if err := secretStore.Write(secretId, *secret.Value, store.WriteOptions{KMSKey: keyID}); err != nil {
+			return errors.Wrapf(err, "Failed to encrypt %s again", k)
+		}
+		count++
+	}
+
+	if !dryRun {
+		fmt.Fprintf(os.Stdout, "Successfully encrypted %d secrets with %s\n", count, keyID)
+	}
+	return nil
+}
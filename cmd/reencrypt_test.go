@@ -0,0 +1,29 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/segmentio/chamber/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReencryptService(t *testing.T) {
+	secretStore := &fakeTypedStore{secrets: map[store.SecretId]store.Secret{}}
+	for k, meta := range map[string][2]string{
+		"password": {store.TypeSecureString, "alias/aws/ssm"},
+		"token":    {store.TypeSecureString, "alias/new_key"},
+		"hostname": {store.TypeString, ""},
+		"hosts":    {store.TypeStringList, ""},
+	} {
+		id := store.SecretId{Service: "api", Key: k}
+		secretStore.secrets[id] = newTypedSecret(id, "value", meta[0], meta[1])
+	}
+
+	assert.Nil(t, reencryptService(secretStore, "api", "alias/new_key"))
+
+	assert.Equal(t, []fakeWrite{{
+		id:    store.SecretId{Service: "api", Key: "password"},
+		value: "value",
+		opts:  store.WriteOptions{KMSKey: "alias/new_key"},
+	}}, secretStore.writes)
+}
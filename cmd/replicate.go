@@ -0,0 +1,207 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/segmentio/chamber/store"
+	"github.com/spf13/cobra"
+)
+
+var (
+	replicateFrom  string
+	replicateTo    []string
+	replicateCheck bool
+
+	// replicateCmd represents the replicate command
+	replicateCmd = &cobra.Command{
+		Use:   "replicate [<service...>] --from <region> --to <region,...>",
+		Short: "Copy the secrets of services to other regions",
+		Long: `Copy the secrets of services to other regions.
+
+The latest value, type, description and tags of every secret of the services
+in the --from region are written to the --to regions, where they are encrypted
+with the KMS key configured for the service, which must exist in every region.
+Secrets that are already up to date are left untouched.
+
+With --check, secrets that are missing or differ in the --to regions are
+reported instead, and the command fails if there are any.  Secrets that only
+exist in the --to regions are reported but never deleted.`,
+		RunE: replicate,
+	}
+)
+
+func init() {
+	replicateCmd.Flags().StringVar(&replicateFrom, "from", "", "Region to copy secrets from")
+	replicateCmd.Flags().StringSliceVar(&replicateTo, "to", []string{}, "Regions to copy secrets to")
+	replicateCmd.Flags().BoolVar(&replicateCheck, "check", false, "Only report the secrets that differ between regions")
+	replicateCmd.Flags().StringVar(&kmsKey, "kms-key", "", "KMS key alias to encrypt the secrets with (default is the key configured for the service)")
+	RootCmd.AddCommand(replicateCmd)
+}
+
+func replicate(cmd *cobra.Command, args []string) error {
+	if replicateFrom == "" || len(replicateTo) == 0 {
+		return errors.New("--from and --to must be given")
+	}
+	for _, region := range replicateTo {
+		if region == replicateFrom {
+			return errors.Errorf("Region %s is both the source and a destination", region)
+		}
+	}
+
+	services, err := servicesOrDefault(args)
+	if err != nil {
+		return err
+	}
+	refs, err := parseServiceRefs(services)
+	if err != nil {
+		return err
+	}
+	for _, ref := range refs {
+		if ref.location() != "" {
+			return errors.Errorf("Service %s must be given without an account or region, use --from and --to", ref)
+		}
+	}
+
+	source, err := locationStore(serviceRef{Region: replicateFrom})
+	if err != nil {
+		return err
+	}
+
+	drifted, replicated := 0, 0
+	for _, ref := range refs {
+		service := ref.Service
+		serviceKMSKey := kmsKeyFor(service)
+		secrets, err := source.List(service, true, true)
+		if err != nil {
+			return errors.Wrapf(err, "Failed to list secrets of %s in %s", service, replicateFrom)
+		}
+		if err := checkTagsRead(secrets); err != nil {
+			return errors.Wrapf(err, "Failed to replicate %s from %s", service, replicateFrom)
+		}
+		sort.Slice(secrets, func(i, j int) bool { return secrets[i].Meta.Key < secrets[j].Meta.Key })
+
+		for _, region := range replicateTo {
+			if err := checkKMSKeyRegion(serviceKMSKey, region); err != nil {
+				return err
+			}
+			target, err := locationStore(serviceRef{Region: region})
+			if err != nil {
+				return err
+			}
+			targetSecrets, err := target.List(service, true, true)
+			if err != nil {
+				return errors.Wrapf(err, "Failed to list secrets of %s in %s", service, region)
+			}
+			if err := checkTagsRead(targetSecrets); err != nil {
+				return errors.Wrapf(err, "Failed to compare %s in %s", service, region)
+			}
+			existing := map[string]store.Secret{}
+			for _, secret := range targetSecrets {
+				existing[secret.Meta.Key] = secret
+			}
+
+			replicatedKeys := map[string]bool{}
+			for _, secret := range secrets {
+				k := secret.Meta.Key
+				current, ok := existing[k]
+				replicatedKeys[k] = true
+
+				reasons := []string{"missing"}
+				if ok {
+					reasons = secretDrift(secret, current)
+				}
+				if len(reasons) == 0 {
+					continue
+				}
+
+				drifted++
+				if replicateCheck {
+					fmt.Fprintf(os.Stdout, "%s: %s/%s differs: %s\n", region, service, key(k), strings.Join(reasons, ", "))
+					continue
+				}
+
+				secretId := store.SecretId{Service: service, Key: key(k)}
+				if err := target.Write(secretId, *secret.Value, replicationOptions(service, secret)); err != nil {
+					return errors.Wrapf(err, "Failed to write %s/%s in %s", service, secretId.Key, region)
+				}
+				replicated++
+				fmt.Fprintf(os.Stdout, "%s: %s/%s replicated (%s)\n", region, service, secretId.Key, strings.Join(reasons, ", "))
+			}
+
+			extra := []string{}
+			for k := range existing {
+				if !replicatedKeys[k] {
+					extra = append(extra, key(k))
+				}
+			}
+			sort.Strings(extra)
+			for _, k := range extra {
+				fmt.Fprintf(os.Stdout, "%s: %s/%s only exists in %s\n", region, service, k, region)
+			}
+		}
+	}
+
+	if replicateCheck {
+		if drifted > 0 {
+			return errors.Errorf("%d secrets differ from %s", drifted, replicateFrom)
+		}
+		fmt.Fprintf(os.Stdout, "All secrets are in sync with %s\n", replicateFrom)
+		return nil
+	}
+	fmt.Fprintf(os.Stdout, "Successfully replicated %d secrets from %s\n", replicated, replicateFrom)
+	return nil
+}
+
+// replicationOptions returns the options a secret of service is replicated
+// with: its description, tags and type, and the KMS key of the service if it
+// is a SecureString
+func replicationOptions(service string, secret store.Secret) store.WriteOptions {
+	paramType := writtenType(secret.Meta.Type, store.Secret{})
+	return store.WriteOptions{
+		Description: secret.Meta.Description,
+		Tags:        secret.Meta.Tags,
+		KMSKey:      kmsKeyForType(service, paramType),
+		Type:        paramType,
+	}
+}
+
+// secretDrift returns how target differs from source.  Descriptions and tags
+// only set in target are not reported, since writing source would not remove
+// them.
+func secretDrift(source, target store.Secret) []string {
+	reasons := []string{}
+	if source.Value == nil || target.Value == nil || *source.Value != *target.Value {
+		reasons = append(reasons, "value")
+	}
+	if source.Meta.Type != target.Meta.Type {
+		reasons = append(reasons, "type")
+	}
+	if source.Meta.Description != "" && source.Meta.Description != target.Meta.Description {
+		reasons = append(reasons, "description")
+	}
+	for k, v := range source.Meta.Tags {
+		if target.Meta.Tags[k] != v {
+			reasons = append(reasons, "tags")
+			break
+		}
+	}
+	return reasons
+}
+
+// checkKMSKeyRegion checks that a KMS key given as an ARN is in region.
+// Aliases and key IDs are resolved in the region secrets are written to.
+func checkKMSKeyRegion(key, region string) error {
+	if !strings.HasPrefix(key, "arn:") {
+		return nil
+	}
+	// arn:aws:kms:<region>:<account>:<resource>
+	parts := strings.SplitN(key, ":", 5)
+	if len(parts) == 5 && parts[3] != region {
+		return errors.Errorf("KMS key %s is not in region %s, configure the key with an alias instead", key, region)
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/segmentio/chamber/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSecretDrift(t *testing.T) {
+	secret := func(value, paramType, description string, tags map[string]string) store.Secret {
+		return store.Secret{
+			Value: &value,
+			Meta:  store.SecretMetadata{Type: paramType, Description: description, Tags: tags},
+		}
+	}
+	source := secret("value", store.TypeSecureString, "Database password", map[string]string{"owner": "payments"})
+
+	assert.Equal(t, []string{}, secretDrift(source, secret("value", store.TypeSecureString, "Database password", map[string]string{"owner": "payments", "extra": "tag"})))
+	assert.Equal(t, []string{"value"}, secretDrift(source, secret("other", store.TypeSecureString, "Database password", map[string]string{"owner": "payments"})))
+	assert.Equal(t, []string{"type", "description", "tags"}, secretDrift(source, secret("value", store.TypeString, "", map[string]string{})))
+
+	t.Run("Descriptions only set in the target should not be drift", func(t *testing.T) {
+		source := secret("value", store.TypeSecureString, "", nil)
+		assert.Equal(t, []string{}, secretDrift(source, secret("value", store.TypeSecureString, "Set by hand", nil)))
+	})
+}
+
+func TestCheckKMSKeyRegion(t *testing.T) {
+	assert.Nil(t, checkKMSKeyRegion("", "us-west-2"))
+	assert.Nil(t, checkKMSKeyRegion("parameter_store_key", "us-west-2"))
+	assert.Nil(t, checkKMSKeyRegion("arn:aws:kms:us-west-2:123456789012:key/abcd", "us-west-2"))
+	assert.NotNil(t, checkKMSKeyRegion("arn:aws:kms:us-east-1:123456789012:key/abcd", "us-west-2"))
+}
+
+func TestReplicationOptions(t *testing.T) {
+	defer func() { config = configSettings{} }()
+	config.KMSKeys = []kmsKeyMapping{{Pattern: "billing", Key: "billing_key"}}
+
+	target := &fakeTypedStore{secrets: map[store.SecretId]store.Secret{}}
+	for _, test := range []struct {
+		key       string
+		paramType string
+		expected  store.WriteOptions
+	}{
+		{key: "password", paramType: store.TypeSecureString, expected: store.WriteOptions{Type: store.TypeSecureString, KMSKey: "billing_key"}},
+		{key: "hostname", paramType: store.TypeString, expected: store.WriteOptions{Type: store.TypeString}},
+		{key: "hosts", paramType: store.TypeStringList, expected: store.WriteOptions{Type: store.TypeStringList}},
+	} {
+		t.Run("Secrets of type "+test.paramType+" should be replicated with their type", func(t *testing.T) {
+			id := store.SecretId{Service: "billing", Key: test.key}
+			secret := newTypedSecret(id, "value", test.paramType, "")
+
+			opts := replicationOptions("billing", secret)
+			assert.Equal(t, test.expected, opts)
+			assert.Nil(t, target.Write(id, *secret.Value, opts))
+			assert.Equal(t, test.paramType, target.secrets[id].Meta.Type)
+		})
+	}
+}
@@ -0,0 +1,168 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/segmentio/chamber/store"
+	"github.com/spf13/cobra"
+)
+
+var (
+	overwrite bool
+
+	// restoreCmd represents the restore command
+	restoreCmd = &cobra.Command{
+		Use:   "restore <file>",
+		Short: "Restore the secrets of services from an encrypted backup",
+		Long: `Restore the secrets of services from an encrypted backup.
+
+The backed up versions of every secret are written again in order, so they
+are numbered from the next version of the secret and attributed to the user
+running restore; the original version numbers, authors and dates are kept in
+the backup file only.  Secrets that already exist are skipped, unless
+--overwrite is given.`,
+		Args: cobra.ExactArgs(1),
+		RunE: runRestore,
+	}
+)
+
+func init() {
+	restoreCmd.Flags().StringVar(&passphraseFile, "passphrase-file", "", "File containing the passphrase the backup is encrypted with (default is $CHAMBER_BACKUP_PASSPHRASE)")
+	restoreCmd.Flags().BoolVar(&overwrite, "overwrite", false, "Write the backed up value of secrets that already exist as a new version, instead of skipping them")
+	RootCmd.AddCommand(restoreCmd)
+}
+
+func runRestore(cmd *cobra.Command, args []string) error {
+	passphrase, err := readPassphrase()
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Open(args[0])
+	if err != nil {
+		return errors.Wrap(err, "Failed to open file")
+	}
+	defer file.Close()
+
+	var encrypted encryptedBackup
+	if err := json.NewDecoder(file).Decode(&encrypted); err != nil {
+		return errors.Wrap(err, "Failed to decode backup")
+	}
+
+	plaintext, err := decryptBackup(encrypted, passphrase)
+	if err != nil {
+		return errors.Wrap(err, "Failed to decrypt backup")
+	}
+
+	var b backup
+	if err := json.Unmarshal(plaintext, &b); err != nil {
+		return errors.Wrap(err, "Failed to decode backup")
+	}
+
+	if err := validateBackup(b); err != nil {
+		return err
+	}
+
+	services := []string{}
+	for service := range b.Services {
+		services = append(services, service)
+	}
+	sort.Strings(services)
+
+	secretStore := getSecretStore()
+	restored, skipped := 0, 0
+	for _, service := range services {
+		ids := []store.SecretId{}
+		for _, secret := range b.Services[service] {
+			ids = append(ids, store.SecretId{Service: service, Key: secret.Key})
+		}
+		existing, err := secretStore.ReadMany(ids)
+		if err != nil {
+			return errors.Wrapf(err, "Failed to read secrets of service %s", service)
+		}
+
+		for _, secret := range b.Services[service] {
+			if len(secret.Versions) == 0 {
+				continue
+			}
+
+			secretId := store.SecretId{
+				Service: service,
+				Key:     secret.Key,
+			}
+
+			versions := secret.Versions
+			if _, ok := existing[secretId]; ok {
+				if !overwrite {
+					fmt.Fprintf(os.Stderr, "warning: skipping %s/%s, which already exists\n", service, secret.Key)
+					skipped++
+					continue
+				}
+				// Only the latest value is written on top of an existing secret
+				versions = versions[len(versions)-1:]
+			}
+
+			if err := restoreVersions(secretStore, secretId, secret, versions, existing[secretId]); err != nil {
+				return errors.Wrapf(err, "Failed to write %s/%s", service, secret.Key)
+			}
+			restored++
+		}
+	}
+
+	fmt.Fprintf(os.Stdout, "Successfully restored %d secrets (%d skipped) from a backup taken %s\n",
+		restored,
+		skipped,
+		b.Created.Local().Format(ShortTimeFormat))
+	return nil
+}
+
+// validateBackup checks the name of every service and secret in a backup, so
+// that a corrupted or crafted backup can't write secrets the other commands
+// would refuse to before anything is restored
+func validateBackup(b backup) error {
+	for service, secrets := range b.Services {
+		if err := validateService(service); err != nil {
+			return errors.Wrap(err, "Failed to validate backed up service")
+		}
+		for _, secret := range secrets {
+			if err := validateKey(secret.Key); err != nil {
+				return errors.Wrapf(err, "Failed to validate backed up key of service %s", service)
+			}
+		}
+	}
+	return nil
+}
+
+// restoreVersions writes the backed up versions of a secret in order, with
+// the type they were stored with.  SecureString versions are encrypted with
+// the KMS key configured for the service, or else the store's default key.
+// The backed up key is only reused when it is an alias and no default key is
+// configured, as key IDs and ARNs don't resolve in other accounts or
+// regions.  existing is the secret the versions are written on top of, if
+// any.
+func restoreVersions(secretStore store.Store, secretId store.SecretId, secret backupSecret, versions []backupVersion, existing store.Secret) error {
+	for i, version := range versions {
+		paramType := writtenType(version.Type, existing)
+		opts := store.WriteOptions{
+			KMSKey: kmsKeyForType(secretId.Service, paramType),
+			Type:   version.Type,
+		}
+		if opts.KMSKey == "" && paramType == store.TypeSecureString &&
+			!defaultKMSKeyConfigured() && strings.HasPrefix(version.KMSKey, "alias/") {
+			opts.KMSKey = version.KMSKey
+		}
+		if i == len(versions)-1 {
+			opts.Description = secret.Description
+			opts.Tags = secret.Tags
+		}
+		if err := secretStore.Write(secretId, version.Value, opts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
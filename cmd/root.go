@@ -2,10 +2,14 @@ package cmd
 
 import (
 	"fmt"
+	"math"
 	"os"
 	"regexp"
+	"sort"
 	"strings"
+	"sync"
 
+	"github.com/segmentio/chamber/store"
 	"github.com/spf13/cobra"
 )
 
@@ -15,7 +19,15 @@ var (
 	validServiceFormat = regexp.MustCompile(`^[A-Za-z0-9-_]+$`)
 
 	numRetries     int
+	rateLimit      float64
+	verbose        bool
 	chamberVersion string
+
+	// retryStats counts the retried requests of every secret store, and
+	// rateLimiter is shared by all of them
+	retryStats      = store.NewRetryStats()
+	rateLimiter     *store.RateLimiter
+	rateLimiterOnce sync.Once
 )
 
 const (
@@ -35,13 +47,21 @@ var RootCmd = &cobra.Command{
 
 func init() {
 	RootCmd.PersistentFlags().IntVarP(&numRetries, "retries", "r", DefaultNumRetries, "For SSM, the number of retries we'll make before giving up")
+	RootCmd.PersistentFlags().Float64Var(&rateLimit, "rate-limit", 0, "For SSM, the maximum number of requests per second (default is no limit)")
+	RootCmd.PersistentFlags().BoolVar(&verbose, "verbose", false, "Log the region, KMS keys and retries of requests to stderr, and a summary of retried requests")
+	RootCmd.PersistentFlags().BoolVar(&debug, "debug", false, "Log every request made to the secret store to stderr, in addition to --verbose")
+	RootCmd.PersistentFlags().StringVar(&logFormat, "log-format", "text", "Format of --verbose and --debug logs, text or json")
+	RootCmd.PersistentPreRunE = prepareRun
+	RootCmd.PersistentFlags().IntVar(&concurrency, "concurrency", DefaultConcurrency, "The number of services whose secrets are read at once")
 }
 
 // Execute adds all child commands to the root command sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute(vers string) {
 	chamberVersion = vers
-	if cmd, err := RootCmd.ExecuteC(); err != nil {
+	cmd, err := RootCmd.ExecuteC()
+	reportRetries()
+	if err != nil {
 		if strings.Contains(err.Error(), "arg(s)") || strings.Contains(err.Error(), "usage") {
 			cmd.Usage()
 		}
@@ -49,10 +69,64 @@ func Execute(vers string) {
 	}
 }
 
+// prepareRun sets up logging and loads the project config before any
+// command runs
+func prepareRun(cmd *cobra.Command, args []string) error {
+	if err := setupLogging(); err != nil {
+		return err
+	}
+	return loadConfig(cmd, args)
+}
+
+// getSecretStore returns the store secrets are read from and written to.
+// Changes made through it are passed on to a running agent.
+func getSecretStore() store.Store {
+	s, err := newSSMStore(authOptions())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: Failed to set up the secret store: %s\n", err)
+		os.Exit(1)
+	}
+	return &agentRefreshingStore{Store: s, agent: connectAgent()}
+}
+
+// newSSMStore returns an SSM store using the given credentials, sharing the
+// rate limiter and retry stats of the other stores
+func newSSMStore(auth store.AuthOptions) (store.Store, error) {
+	s, err := store.NewSSMStoreWithAuth(numRetries, auth)
+	if err != nil {
+		return nil, err
+	}
+	s.SetRetryStats(retryStats)
+	s.SetUsePaths(!noPaths())
+	s.SetKMSKeyAlias(config.KMSKeyAlias)
+	if rateLimit > 0 {
+		rateLimiterOnce.Do(func() {
+			rateLimiter = store.NewRateLimiter(rateLimit, int(math.Ceil(rateLimit)))
+		})
+		s.SetRateLimiter(rateLimiter)
+	}
+	return s, nil
+}
+
+// reportRetries prints how many requests were retried with --verbose
+func reportRetries() {
+	if !verbose && !debug {
+		return
+	}
+	retries, throttles := retryStats.Retries()
+	fmt.Fprintf(os.Stderr, "%d requests retried, %d throttled\n", retries, throttles)
+	if retries > 0 {
+		fmt.Fprintln(os.Stderr, retryStats.Summary())
+	}
+}
+
 func validateService(service string) error {
 	if !validServiceFormat.MatchString(service) {
 		return fmt.Errorf("Failed to validate service name '%s'.  Only alphanumeric, dashes, and underscores are allowed for service names", service)
 	}
+	if service == store.DeletedService {
+		return fmt.Errorf("Failed to validate service name '%s'.  This service name is reserved for deleted secrets", service)
+	}
 	return nil
 }
 
@@ -62,3 +136,29 @@ func validateKey(key string) error {
 	}
 	return nil
 }
+
+// parseTags parses tags given on the command line as key=value pairs
+func parseTags(tags []string) (map[string]string, error) {
+	parsed := map[string]string{}
+	for _, tag := range tags {
+		parts := strings.SplitN(tag, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("Failed to parse tag '%s'.  Tags must be given as key=value", tag)
+		}
+		if strings.HasPrefix(parts[0], store.ReservedTagPrefix) {
+			return nil, fmt.Errorf("Tag key '%s' is reserved for use by chamber", parts[0])
+		}
+		parsed[parts[0]] = parts[1]
+	}
+	return parsed, nil
+}
+
+// formatTags formats tags as a sorted, comma separated list of key=value pairs
+func formatTags(tags map[string]string) string {
+	pairs := []string{}
+	for k, v := range tags {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
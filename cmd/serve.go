@@ -0,0 +1,360 @@
+package cmd
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/segmentio/chamber/store"
+	"github.com/spf13/cobra"
+	yaml "gopkg.in/yaml.v2"
+)
+
+var (
+	serveListen     string
+	serveTokensFile string
+	serveCacheTTL   time.Duration
+
+	// serveCmd represents the serve command
+	serveCmd = &cobra.Command{
+		Use:   "serve --listen <address> --tokens-file <file>",
+		Short: "Serve secrets over a read-only HTTP API",
+		Long: `Serve secrets over a read-only HTTP API.
+
+The following endpoints are served:
+
+  GET /v1/services/<service>        all secrets of a service, as a json object
+  GET /v1/services/<service>/<key>  a single secret and its metadata
+
+Binary secrets are served base64 encoded, with an "encoding" field set to
+"base64": in the object of a service, their value is an object holding the
+"value" and its "encoding" instead of a string.
+
+Clients authenticate with an "Authorization: Bearer <token>" header, using one
+of the tokens of the tokens file, which also lists the services each token may
+read:
+
+  clients:
+    - name: billing
+      token: 8f1c...
+      services: [billing, shared]
+    - name: admin
+      token: 03ab...
+      services: ["*"]
+
+The address is either host:port or unix:<path> for a unix socket.`,
+		Args: cobra.NoArgs,
+		RunE: serve,
+	}
+)
+
+// servedBinary is the value of a binary secret in the response for a service
+type servedBinary struct {
+	Value    string `json:"value"`
+	Encoding string `json:"encoding"`
+}
+
+// serveClient is a client allowed to read secrets from the server
+type serveClient struct {
+	Name     string   `yaml:"name"`
+	Token    string   `yaml:"token"`
+	Services []string `yaml:"services"`
+}
+
+// servedSecret is the response for a single secret.  Binary secrets are
+// served base64 encoded, with Encoding set to base64.
+type servedSecret struct {
+	Service     string            `json:"service"`
+	Key         string            `json:"key"`
+	Type        string            `json:"type,omitempty"`
+	Value       string            `json:"value"`
+	Encoding    string            `json:"encoding,omitempty"`
+	Version     int               `json:"version"`
+	ModifiedBy  string            `json:"modified_by"`
+	Modified    time.Time         `json:"modified"`
+	Description string            `json:"description,omitempty"`
+	Tags        map[string]string `json:"tags,omitempty"`
+}
+
+func init() {
+	serveCmd.Flags().StringVarP(&serveListen, "listen", "l", "127.0.0.1:8200", "Address to listen on, either host:port or unix:<path>")
+	serveCmd.Flags().StringVar(&serveTokensFile, "tokens-file", "", "File listing the client tokens and the services each of them may read")
+	serveCmd.Flags().DurationVar(&serveCacheTTL, "cache-ttl", 30*time.Second, "How long secrets are cached for (0 disables caching)")
+	RootCmd.AddCommand(serveCmd)
+}
+
+func serve(cmd *cobra.Command, args []string) error {
+	if serveTokensFile == "" {
+		return errors.New("a tokens file must be given with --tokens-file")
+	}
+	clients, err := readServeClients(serveTokensFile)
+	if err != nil {
+		return errors.Wrap(err, "Failed to read tokens file")
+	}
+
+	secretStore := getSecretStore()
+	if serveCacheTTL > 0 {
+		secretStore = store.NewCachingStore(secretStore, serveCacheTTL)
+	}
+
+	listener, err := listen(serveListen)
+	if err != nil {
+		return errors.Wrap(err, "Failed to listen")
+	}
+
+	log.Printf("serving secrets on %s", serveListen)
+	return serveHTTP(listener, newSecretsHandler(secretStore, clients))
+}
+
+// readServeClients reads the clients of the server from a tokens file
+func readServeClients(file string) ([]serveClient, error) {
+	contents, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var tokens struct {
+		Clients []serveClient `yaml:"clients"`
+	}
+	if err := yaml.Unmarshal(contents, &tokens); err != nil {
+		return nil, err
+	}
+	if len(tokens.Clients) == 0 {
+		return nil, errors.New("no clients are defined")
+	}
+	for _, client := range tokens.Clients {
+		if len(client.Token) < 16 {
+			return nil, errors.Errorf("the token of client %s must be at least 16 characters long", client.Name)
+		}
+	}
+	return tokens.Clients, nil
+}
+
+// listen listens on a TCP address, or on a unix socket if address is of the
+// form unix:<path>.  Unix sockets are only accessible by the current user.
+func listen(address string) (net.Listener, error) {
+	if !strings.HasPrefix(address, "unix:") {
+		return net.Listen("tcp", address)
+	}
+
+	path := strings.TrimPrefix(address, "unix:")
+	if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		// Remove the socket left behind by a previous run, unless something
+		// is still listening on it
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, errors.Errorf("%s is already in use", path)
+		}
+		os.Remove(path)
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
+
+// serveHTTP serves handler on listener until the process is interrupted
+func serveHTTP(listener net.Listener, handler http.Handler) error {
+	server := &http.Server{
+		Handler:      handler,
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 30 * time.Second,
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		<-signals
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		done <- server.Shutdown(ctx)
+	}()
+
+	if err := server.Serve(listener); err != http.ErrServerClosed {
+		return err
+	}
+	return <-done
+}
+
+// secretsHandler serves the read-only secrets API
+type secretsHandler struct {
+	store store.Store
+
+	// clients are the clients allowed to read secrets
+	clients []serveClient
+
+	// anonymous, if set, is the client every request is treated as coming
+	// from, without authentication
+	anonymous *serveClient
+}
+
+func newSecretsHandler(secretStore store.Store, clients []serveClient) http.Handler {
+	return &secretsHandler{store: secretStore, clients: clients}
+}
+
+func (h *secretsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		httpError(w, http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !strings.HasPrefix(r.URL.Path, "/v1/services/") {
+		httpError(w, http.StatusNotFound)
+		return
+	}
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/v1/services/"), "/")
+	if len(parts) > 2 {
+		httpError(w, http.StatusNotFound)
+		return
+	}
+
+	service := strings.ToLower(parts[0])
+	if err := validateService(service); err != nil {
+		httpError(w, http.StatusBadRequest)
+		return
+	}
+
+	client, ok := h.authenticate(r)
+	if !ok {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="chamber"`)
+		httpError(w, http.StatusUnauthorized)
+		return
+	}
+	if !client.allowed(service) {
+		httpError(w, http.StatusForbidden)
+		return
+	}
+
+	if len(parts) == 1 {
+		h.serveService(w, service)
+		return
+	}
+
+	key := strings.ToLower(parts[1])
+	if err := validateKey(key); err != nil {
+		httpError(w, http.StatusBadRequest)
+		return
+	}
+	h.serveSecret(w, store.SecretId{Service: service, Key: key})
+}
+
+func (h *secretsHandler) serveService(w http.ResponseWriter, service string) {
+	rawSecrets, err := h.store.ListRaw(service)
+	if err != nil {
+		log.Printf("failed to list service %s: %s", service, err)
+		httpError(w, http.StatusInternalServerError)
+		return
+	}
+	if len(rawSecrets) == 0 {
+		httpError(w, http.StatusNotFound)
+		return
+	}
+
+	params := make(map[string]interface{}, len(rawSecrets))
+	for _, rawSecret := range rawSecrets {
+		params[key(rawSecret.Key)] = rawSecret.Value
+		if store.IsBinary(rawSecret.Value) {
+			params[key(rawSecret.Key)] = servedBinary{
+				Value:    strings.TrimPrefix(rawSecret.Value, store.BinaryValuePrefix),
+				Encoding: "base64",
+			}
+		}
+	}
+	writeJSON(w, params)
+}
+
+func (h *secretsHandler) serveSecret(w http.ResponseWriter, secretId store.SecretId) {
+	secret, err := h.store.Read(secretId, -1)
+	if err == store.ErrSecretNotFound {
+		httpError(w, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("failed to read %s/%s: %s", secretId.Service, secretId.Key, err)
+		httpError(w, http.StatusInternalServerError)
+		return
+	}
+
+	served := servedSecret{
+		Service:     secretId.Service,
+		Key:         secretId.Key,
+		Type:        secret.Meta.Type,
+		Value:       *secret.Value,
+		Version:     secret.Meta.Version,
+		ModifiedBy:  secret.Meta.CreatedBy,
+		Modified:    secret.Meta.Created.UTC(),
+		Description: secret.Meta.Description,
+		Tags:        secret.Meta.Tags,
+	}
+	if store.IsBinary(served.Value) {
+		served.Value = strings.TrimPrefix(served.Value, store.BinaryValuePrefix)
+		served.Encoding = "base64"
+	}
+	writeJSON(w, served)
+}
+
+// authenticate finds the client whose token was given in the request's
+// Authorization header
+func (h *secretsHandler) authenticate(r *http.Request) (serveClient, bool) {
+	if h.anonymous != nil {
+		return *h.anonymous, true
+	}
+
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return serveClient{}, false
+	}
+
+	// Compare digests so that the comparison takes the same time whatever
+	// the length of the given token
+	given := sha256.Sum256([]byte(strings.TrimPrefix(header, "Bearer ")))
+	for _, client := range h.clients {
+		expected := sha256.Sum256([]byte(client.Token))
+		if subtle.ConstantTimeCompare(given[:], expected[:]) == 1 {
+			return client, true
+		}
+	}
+	return serveClient{}, false
+}
+
+// allowed returns whether the client may read the secrets of service
+func (c serveClient) allowed(service string) bool {
+	for _, allowed := range c.Services {
+		if allowed == "*" || strings.ToLower(allowed) == service {
+			return true
+		}
+	}
+	return false
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(v)
+}
+
+func httpError(w http.ResponseWriter, code int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	fmt.Fprintf(w, "{\"error\":%q}\n", http.StatusText(code))
+}
@@ -0,0 +1,118 @@
+package cmd
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/segmentio/chamber/store"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeStore is an in-memory store holding the latest values of secrets
+type fakeStore struct {
+	store.Store
+	secrets map[store.SecretId]string
+}
+
+func (s *fakeStore) Read(id store.SecretId, version int) (store.Secret, error) {
+	value, ok := s.secrets[id]
+	if !ok {
+		return store.Secret{}, store.ErrSecretNotFound
+	}
+	return store.Secret{
+		Value: &value,
+		Meta:  store.SecretMetadata{Key: "/" + id.Service + "/" + id.Key, Version: 1},
+	}, nil
+}
+
+func (s *fakeStore) ListRaw(service string) ([]store.RawSecret, error) {
+	rawSecrets := []store.RawSecret{}
+	for id, value := range s.secrets {
+		if id.Service == service {
+			rawSecrets = append(rawSecrets, store.RawSecret{Key: "/" + id.Service + "/" + id.Key, Value: value})
+		}
+	}
+	return rawSecrets, nil
+}
+
+func TestSecretsHandler(t *testing.T) {
+	secretStore := &fakeStore{secrets: map[store.SecretId]string{
+		{Service: "billing", Key: "db_password"}: "hunter2",
+		{Service: "billing", Key: "api_key"}:     "abc",
+		{Service: "other", Key: "api_key"}:       "def",
+	}}
+	handler := newSecretsHandler(secretStore, []serveClient{
+		{Name: "billing", Token: "billing-token-0123456789", Services: []string{"billing"}},
+	})
+
+	get := func(path, token string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	t.Run("Reading a service should return all of its secrets", func(t *testing.T) {
+		w := get("/v1/services/billing", "billing-token-0123456789")
+		assert.Equal(t, http.StatusOK, w.Code)
+		params := map[string]string{}
+		assert.Nil(t, json.NewDecoder(w.Body).Decode(&params))
+		assert.Equal(t, map[string]string{"db_password": "hunter2", "api_key": "abc"}, params)
+	})
+
+	t.Run("Reading a key should return its value", func(t *testing.T) {
+		w := get("/v1/services/billing/db_password", "billing-token-0123456789")
+		assert.Equal(t, http.StatusOK, w.Code)
+		var secret servedSecret
+		assert.Nil(t, json.NewDecoder(w.Body).Decode(&secret))
+		assert.Equal(t, "hunter2", secret.Value)
+		assert.Equal(t, 1, secret.Version)
+	})
+
+	t.Run("Binary secrets should be served base64 encoded", func(t *testing.T) {
+		data := []byte{0x00, 0xff, 'k', 'e', 'y'}
+		payload := base64.StdEncoding.EncodeToString(data)
+		secretStore.secrets[store.SecretId{Service: "billing", Key: "keystore"}] = store.EncodeBinary(data)
+		defer delete(secretStore.secrets, store.SecretId{Service: "billing", Key: "keystore"})
+
+		w := get("/v1/services/billing/keystore", "billing-token-0123456789")
+		assert.Equal(t, http.StatusOK, w.Code)
+		var secret servedSecret
+		assert.Nil(t, json.NewDecoder(w.Body).Decode(&secret))
+		assert.Equal(t, payload, secret.Value)
+		assert.Equal(t, "base64", secret.Encoding)
+
+		w = get("/v1/services/billing", "billing-token-0123456789")
+		assert.Equal(t, http.StatusOK, w.Code)
+		params := map[string]interface{}{}
+		assert.Nil(t, json.NewDecoder(w.Body).Decode(&params))
+		assert.Equal(t, "hunter2", params["db_password"])
+		assert.Equal(t, map[string]interface{}{"value": payload, "encoding": "base64"}, params["keystore"])
+	})
+
+	t.Run("Missing secrets should give not found", func(t *testing.T) {
+		assert.Equal(t, http.StatusNotFound, get("/v1/services/billing/nope", "billing-token-0123456789").Code)
+	})
+
+	t.Run("Requests without a valid token should be rejected", func(t *testing.T) {
+		assert.Equal(t, http.StatusUnauthorized, get("/v1/services/billing", "").Code)
+		assert.Equal(t, http.StatusUnauthorized, get("/v1/services/billing", "wrong-token-0123456789").Code)
+	})
+
+	t.Run("Services outside of the client's allowlist should be forbidden", func(t *testing.T) {
+		assert.Equal(t, http.StatusForbidden, get("/v1/services/other/api_key", "billing-token-0123456789").Code)
+	})
+
+	t.Run("Only GET should be allowed", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodPost, "/v1/services/billing", nil)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+	})
+}
@@ -0,0 +1,76 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/pkg/errors"
+	"github.com/segmentio/chamber/store"
+	"github.com/spf13/cobra"
+)
+
+var (
+	listDeleted bool
+
+	// undeleteCmd represents the undelete command
+	undeleteCmd = &cobra.Command{
+		Use:   "undelete <service> <key>",
+		Short: "Restore a deleted secret, including all versions, from the recycle bin",
+		Args: func(cmd *cobra.Command, args []string) error {
+			if listDeleted {
+				return cobra.NoArgs(cmd, args)
+			}
+			return cobra.ExactArgs(2)(cmd, args)
+		},
+		RunE: undelete,
+	}
+)
+
+func init() {
+	undeleteCmd.Flags().BoolVarP(&listDeleted, "list", "l", false, "List the secrets in the recycle bin")
+	RootCmd.AddCommand(undeleteCmd)
+}
+
+func undelete(cmd *cobra.Command, args []string) error {
+	secretStore := getSecretStore()
+
+	if listDeleted {
+		deleted, err := secretStore.ListDeleted()
+		if err != nil {
+			return errors.Wrap(err, "Failed to list the recycle bin")
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, '\t', 0)
+		fmt.Fprintln(w, "Service\tKey\tDeleted")
+		for _, d := range deleted {
+			fmt.Fprintf(w, "%s\t%s\t%s\n",
+				d.Id.Service,
+				d.Id.Key,
+				d.DeletedAt.Local().Format(ShortTimeFormat))
+		}
+		w.Flush()
+		return nil
+	}
+
+	service := strings.ToLower(args[0])
+	if err := validateService(service); err != nil {
+		return errors.Wrap(err, "Failed to validate service")
+	}
+
+	key := strings.ToLower(args[1])
+	if err := validateKey(key); err != nil {
+		return errors.Wrap(err, "Failed to validate key")
+	}
+
+	secretId := store.SecretId{
+		Service: service,
+		Key:     key,
+	}
+
+	if err := secretStore.Undelete(secretId); err != nil {
+		return errors.Wrap(err, "Failed to undelete")
+	}
+	return nil
+}
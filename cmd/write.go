@@ -12,7 +12,11 @@ import (
 )
 
 var (
-	singleline bool
+	singleline  bool
+	description string
+	tags        []string
+	paramType   string
+	binaryValue bool
 
 	// writeCmd represents the write command
 	writeCmd = &cobra.Command{
@@ -25,6 +29,11 @@ var (
 
 func init() {
 	writeCmd.Flags().BoolVarP(&singleline, "singleline", "s", false, "Insert single line parameter (end with \\n)")
+	writeCmd.Flags().StringVarP(&description, "description", "d", "", "Human readable description of the secret")
+	writeCmd.Flags().StringArrayVarP(&tags, "tag", "t", []string{}, "Tag to attach to the secret, as key=value (can be repeated)")
+	writeCmd.Flags().BoolVar(&binaryValue, "binary", false, "Store the value as binary content, base64 encoded")
+	writeCmd.Flags().StringVar(&paramType, "type", "", "Parameter type of the secret: securestring, string or stringlist (default is securestring for new secrets, the current type otherwise)")
+	writeCmd.Flags().StringVar(&kmsKey, "kms-key", "", "KMS key to encrypt the secret with, for securestring secrets (default is the key configured for the service)")
 	RootCmd.AddCommand(writeCmd)
 }
 
@@ -39,10 +48,20 @@ func write(cmd *cobra.Command, args []string) error {
 		return errors.Wrap(err, "Failed to validate key")
 	}
 
+	tagMap, err := parseTags(tags)
+	if err != nil {
+		return errors.Wrap(err, "Failed to parse tags")
+	}
+
+	storeType, err := parseParamType(paramType)
+	if err != nil {
+		return err
+	}
+
 	value := args[2]
 	if value == "-" {
 		// Read value from standard input
-		if singleline {
+		if singleline && !binaryValue {
 			buf := bufio.NewReader(os.Stdin)
 			v, err := buf.ReadString('\n')
 			if err != nil {
@@ -58,11 +77,54 @@ func write(cmd *cobra.Command, args []string) error {
 		}
 	}
 
-	secretStore := store.NewSSMStore(numRetries)
+	if binaryValue {
+		value = store.EncodeBinary([]byte(value))
+	} else if store.IsBinary(value) {
+		return errors.Errorf("Values starting with %s are reserved for binary values, use --binary", store.BinaryValuePrefix)
+	}
+
+	secretStore := getSecretStore()
 	secretId := store.SecretId{
 		Service: service,
 		Key:     key,
 	}
 
-	return secretStore.Write(secretId, value)
+	// The store rejects --kms-key for secrets that aren't encrypted, while
+	// the key configured for the service only applies to encrypted ones
+	return secretStore.Write(secretId, value, store.WriteOptions{
+		Description:   description,
+		Tags:          tagMap,
+		KMSKey:        kmsKey,
+		DefaultKMSKey: kmsKeyFor(service),
+		Type:          storeType,
+	})
+}
+
+// writtenType returns the type a secret is stored as when written with
+// paramType: paramType if given, or else the type of the existing secret, and
+// TypeSecureString for new secrets
+func writtenType(paramType string, existing store.Secret) string {
+	if paramType != "" {
+		return paramType
+	}
+	if existing.Meta.Type != "" {
+		return existing.Meta.Type
+	}
+	return store.TypeSecureString
+}
+
+// parseParamType converts a parameter type given on the command line to the
+// store's name for it
+func parseParamType(t string) (string, error) {
+	switch strings.ToLower(t) {
+	case "":
+		return "", nil
+	case "securestring":
+		return store.TypeSecureString, nil
+	case "string":
+		return store.TypeString, nil
+	case "stringlist":
+		return store.TypeStringList, nil
+	}
+	return "", errors.Errorf("Unsupported parameter type %s.  Supported types are securestring, string and stringlist", t)
 }
@@ -0,0 +1,107 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/segmentio/chamber/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteTagFlag(t *testing.T) {
+	defer func() { tags = []string{} }()
+
+	assert.Nil(t, writeCmd.Flags().Parse([]string{"--tag", "owner=payments", "-t", "note=a,b"}))
+	assert.Equal(t, []string{"owner=payments", "note=a,b"}, tags)
+
+	parsed, err := parseTags(tags)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"owner": "payments", "note": "a,b"}, parsed)
+}
+
+// fakeTypedStore is an in-memory store keeping the type and KMS key of
+// secrets, which rejects KMS keys for unencrypted types like SSMStore does
+type fakeTypedStore struct {
+	store.Store
+	secrets map[store.SecretId]store.Secret
+	writes  []fakeWrite
+}
+
+type fakeWrite struct {
+	id    store.SecretId
+	value string
+	opts  store.WriteOptions
+}
+
+func (s *fakeTypedStore) Read(id store.SecretId, version int) (store.Secret, error) {
+	secret, ok := s.secrets[id]
+	if !ok {
+		return store.Secret{}, store.ErrSecretNotFound
+	}
+	return secret, nil
+}
+
+func (s *fakeTypedStore) ReadMany(ids []store.SecretId) (map[store.SecretId]store.Secret, error) {
+	read := map[store.SecretId]store.Secret{}
+	for _, id := range ids {
+		if secret, ok := s.secrets[id]; ok {
+			read[id] = secret
+		}
+	}
+	return read, nil
+}
+
+func (s *fakeTypedStore) List(service string, includeValues bool, includeTags bool) ([]store.Secret, error) {
+	secrets := []store.Secret{}
+	for id, secret := range s.secrets {
+		if id.Service == service {
+			secrets = append(secrets, secret)
+		}
+	}
+	return secrets, nil
+}
+
+func (s *fakeTypedStore) Write(id store.SecretId, value string, opts store.WriteOptions) error {
+	paramType := writtenType(opts.Type, s.secrets[id])
+	if paramType != store.TypeSecureString && opts.KMSKey != "" {
+		return errors.Errorf("a KMS key can only be used with %s secrets", store.TypeSecureString)
+	}
+	s.writes = append(s.writes, fakeWrite{id: id, value: value, opts: opts})
+	key := opts.KMSKey
+	if key == "" && paramType == store.TypeSecureString {
+		key = opts.DefaultKMSKey
+	}
+	s.secrets[id] = newTypedSecret(id, value, paramType, store.KMSKeyID(key))
+	return nil
+}
+
+func (s *fakeTypedStore) SoftDelete(id store.SecretId) error {
+	secrets := map[store.SecretId]store.Secret{}
+	for existing, secret := range s.secrets {
+		if existing != id {
+			secrets[existing] = secret
+		}
+	}
+	s.secrets = secrets
+	return nil
+}
+
+func newTypedSecret(id store.SecretId, value, paramType, kmsKey string) store.Secret {
+	return store.Secret{
+		Value: &value,
+		Meta: store.SecretMetadata{
+			Key:    "/" + id.Service + "/" + id.Key,
+			Type:   paramType,
+			KMSKey: kmsKey,
+		},
+	}
+}
+
+func TestWrittenType(t *testing.T) {
+	stringSecret := newTypedSecret(store.SecretId{Service: "api", Key: "host"}, "db", store.TypeString, "")
+
+	assert.Equal(t, store.TypeSecureString, writtenType("", store.Secret{}))
+	assert.Equal(t, store.TypeString, writtenType(store.TypeString, store.Secret{}))
+	assert.Equal(t, store.TypeString, writtenType("", stringSecret))
+	assert.Equal(t, store.TypeStringList, writtenType(store.TypeStringList, stringSecret))
+}
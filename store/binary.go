@@ -0,0 +1,29 @@
+package store
+
+import (
+	"encoding/base64"
+	"strings"
+)
+
+// BinaryValuePrefix marks values holding base64 encoded binary content, which
+// can not be stored in parameters as is
+const BinaryValuePrefix = "chamber:base64:"
+
+// EncodeBinary encodes binary content into a value that can be written to the
+// store
+func EncodeBinary(data []byte) string {
+	return BinaryValuePrefix + base64.StdEncoding.EncodeToString(data)
+}
+
+// IsBinary returns whether value holds binary content encoded by EncodeBinary
+func IsBinary(value string) bool {
+	return strings.HasPrefix(value, BinaryValuePrefix)
+}
+
+// DecodeValue returns the content of a value, decoding it if it is binary
+func DecodeValue(value string) ([]byte, error) {
+	if !IsBinary(value) {
+		return []byte(value), nil
+	}
+	return base64.StdEncoding.DecodeString(strings.TrimPrefix(value, BinaryValuePrefix))
+}
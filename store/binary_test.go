@@ -0,0 +1,25 @@
+package store
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBinaryValues(t *testing.T) {
+	data := []byte{0x00, 0x01, 0xff, '\n'}
+
+	value := EncodeBinary(data)
+	assert.True(t, IsBinary(value))
+	decoded, err := DecodeValue(value)
+	assert.Nil(t, err)
+	assert.Equal(t, data, decoded)
+
+	assert.False(t, IsBinary("plain"))
+	decoded, err = DecodeValue("plain")
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("plain"), decoded)
+
+	_, err = DecodeValue(BinaryValuePrefix + "not base64!")
+	assert.NotNil(t, err)
+}
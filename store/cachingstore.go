@@ -0,0 +1,130 @@
+package store
+
+import (
+	"sync"
+	"time"
+)
+
+// ensure CachingStore confirms to Store interface
+var _ Store = &CachingStore{}
+
+// CachingStore is a Store that caches the latest values read from another
+// Store for a fixed amount of time.  Only reads of the latest version of a
+// secret and ListRaw are cached; all other calls are passed through, and
+// calls that modify a service drop the cached entries of that service.
+type CachingStore struct {
+	Store
+	ttl time.Duration
+	now func() time.Time
+
+	mu      sync.Mutex
+	secrets map[SecretId]cachedSecret
+	lists   map[string]cachedList
+}
+
+type cachedSecret struct {
+	secret  Secret
+	expires time.Time
+}
+
+type cachedList struct {
+	secrets []RawSecret
+	expires time.Time
+}
+
+// NewCachingStore returns a Store that caches the latest values read from
+// backend for ttl
+func NewCachingStore(backend Store, ttl time.Duration) *CachingStore {
+	return &CachingStore{
+		Store:   backend,
+		ttl:     ttl,
+		now:     time.Now,
+		secrets: map[SecretId]cachedSecret{},
+		lists:   map[string]cachedList{},
+	}
+}
+
+// Read reads a secret, serving the latest version from the cache if it has
+// not expired yet
+func (s *CachingStore) Read(id SecretId, version int) (Secret, error) {
+	if version != -1 {
+		return s.Store.Read(id, version)
+	}
+
+	s.mu.Lock()
+	cached, ok := s.secrets[id]
+	s.mu.Unlock()
+	if ok && s.now().Before(cached.expires) {
+		return cached.secret, nil
+	}
+
+	secret, err := s.Store.Read(id, version)
+	if err != nil {
+		return Secret{}, err
+	}
+
+	s.mu.Lock()
+	s.secrets[id] = cachedSecret{secret: secret, expires: s.now().Add(s.ttl)}
+	s.mu.Unlock()
+	return secret, nil
+}
+
+// ListRaw lists the keys and values of a service, serving them from the
+// cache if they have not expired yet
+func (s *CachingStore) ListRaw(service string) ([]RawSecret, error) {
+	s.mu.Lock()
+	cached, ok := s.lists[service]
+	s.mu.Unlock()
+	if ok && s.now().Before(cached.expires) {
+		return cached.secrets, nil
+	}
+
+	secrets, err := s.Store.ListRaw(service)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	s.lists[service] = cachedList{secrets: secrets, expires: s.now().Add(s.ttl)}
+	s.mu.Unlock()
+	return secrets, nil
+}
+
+// Invalidate drops every cached entry
+func (s *CachingStore) Invalidate() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.secrets = map[SecretId]cachedSecret{}
+	s.lists = map[string]cachedList{}
+}
+
+func (s *CachingStore) Write(id SecretId, value string, opts WriteOptions) error {
+	defer s.invalidateService(id.Service)
+	return s.Store.Write(id, value, opts)
+}
+
+func (s *CachingStore) Delete(id SecretId) error {
+	defer s.invalidateService(id.Service)
+	return s.Store.Delete(id)
+}
+
+func (s *CachingStore) SoftDelete(id SecretId) error {
+	defer s.invalidateService(id.Service)
+	return s.Store.SoftDelete(id)
+}
+
+func (s *CachingStore) Undelete(id SecretId) error {
+	defer s.invalidateService(id.Service)
+	return s.Store.Undelete(id)
+}
+
+func (s *CachingStore) invalidateService(service string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.lists, service)
+	for id := range s.secrets {
+		if id.Service == service {
+			delete(s.secrets, id)
+		}
+	}
+}
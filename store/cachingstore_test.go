@@ -0,0 +1,64 @@
+package store
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCachingStore(t *testing.T) {
+	mock := &mockSSMClient{parameters: map[string]mockParameter{}}
+	backend := NewTestSSMStore(mock)
+	secretId := SecretId{Service: "test", Key: "key"}
+	backend.Write(secretId, "value", WriteOptions{})
+
+	now := time.Now()
+	store := NewCachingStore(backend, time.Minute)
+	store.now = func() time.Time { return now }
+
+	s, err := store.Read(secretId, -1)
+	assert.Nil(t, err)
+	assert.Equal(t, "value", *s.Value)
+	list, err := store.ListRaw("test")
+	assert.Nil(t, err)
+	assert.Equal(t, "value", list[0].Value)
+
+	// Changes made behind the cache's back are not seen until it expires
+	backend.Write(secretId, "second value", WriteOptions{})
+
+	t.Run("Cached values should be served until they expire", func(t *testing.T) {
+		s, err := store.Read(secretId, -1)
+		assert.Nil(t, err)
+		assert.Equal(t, "value", *s.Value)
+		list, err := store.ListRaw("test")
+		assert.Nil(t, err)
+		assert.Equal(t, "value", list[0].Value)
+	})
+
+	t.Run("Specific versions should not be cached", func(t *testing.T) {
+		s, err := store.Read(secretId, 2)
+		assert.Nil(t, err)
+		assert.Equal(t, "second value", *s.Value)
+	})
+
+	t.Run("Expired values should be read again", func(t *testing.T) {
+		now = now.Add(2 * time.Minute)
+		s, err := store.Read(secretId, -1)
+		assert.Nil(t, err)
+		assert.Equal(t, "second value", *s.Value)
+		list, err := store.ListRaw("test")
+		assert.Nil(t, err)
+		assert.Equal(t, "second value", list[0].Value)
+	})
+
+	t.Run("Writing through the cache should invalidate the service", func(t *testing.T) {
+		assert.Nil(t, store.Write(secretId, "third value", WriteOptions{}))
+		s, err := store.Read(secretId, -1)
+		assert.Nil(t, err)
+		assert.Equal(t, "third value", *s.Value)
+		list, err := store.ListRaw("test")
+		assert.Nil(t, err)
+		assert.Equal(t, "third value", list[0].Value)
+	})
+}
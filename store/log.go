@@ -0,0 +1,34 @@
+package store
+
+// LogLevel is the verbosity of a log entry
+type LogLevel int
+
+const (
+	// LogInfo entries describe the decisions a store makes, like the region
+	// it uses or falling back to slower APIs
+	LogInfo LogLevel = iota
+
+	// LogDebug entries describe every request a store makes
+	LogDebug
+)
+
+// Logger receives what stores do.  Fields name parameters but never hold
+// secret values.
+type Logger interface {
+	Log(level LogLevel, msg string, fields map[string]interface{})
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Log(level LogLevel, msg string, fields map[string]interface{}) {}
+
+var logger Logger = nopLogger{}
+
+// SetLogger makes stores log to l.  It must be called before stores are
+// created.
+func SetLogger(l Logger) {
+	if l == nil {
+		l = nopLogger{}
+	}
+	logger = l
+}
@@ -0,0 +1,235 @@
+package store
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/service/sts"
+)
+
+// credentialsExpiryWindow is how long before they expire temporary
+// credentials are renewed
+const credentialsExpiryWindow = 5 * time.Minute
+
+// AuthOptions select the AWS credentials of an SSMStore, instead of the
+// default credential chain
+type AuthOptions struct {
+	// Region is the region of the store, instead of CHAMBER_AWS_REGION or
+	// the region of the AWS config
+	Region string
+	// Profile is the shared config profile to use
+	Profile string
+	// RoleARN is a role to assume
+	RoleARN string
+	// ExternalID is the external ID required to assume RoleARN, if any
+	ExternalID string
+	// MFASerial is the MFA device to authenticate with.  Without RoleARN, a
+	// session token is requested with it.
+	MFASerial string
+	// SessionDuration is how long temporary credentials last, the STS
+	// default if zero
+	SessionDuration time.Duration
+	// TokenProvider returns the current code of the MFA device
+	TokenProvider func() (string, error)
+	// CacheDir is the directory temporary credentials are cached in, so that
+	// they can be reused across invocations.  Credentials are not cached if
+	// it is empty.
+	CacheDir string
+}
+
+// stsAPI is the part of the STS API used to get temporary credentials
+type stsAPI interface {
+	AssumeRole(*sts.AssumeRoleInput) (*sts.AssumeRoleOutput, error)
+	GetSessionToken(*sts.GetSessionTokenInput) (*sts.GetSessionTokenOutput, error)
+}
+
+// stsProvider provides temporary credentials from STS, by assuming a role or
+// getting a session token authenticated with MFA
+type stsProvider struct {
+	svc        stsAPI
+	auth       AuthOptions
+	expiration time.Time
+	now        func() time.Time
+}
+
+// cachedCredentials are temporary credentials as cached on disk
+type cachedCredentials struct {
+	AccessKeyID     string    `json:"access_key_id"`
+	SecretAccessKey string    `json:"secret_access_key"`
+	SessionToken    string    `json:"session_token"`
+	Expiration      time.Time `json:"expiration"`
+}
+
+// Retrieve returns cached credentials if they are still valid, and requests
+// new ones otherwise
+func (p *stsProvider) Retrieve() (credentials.Value, error) {
+	file := p.cacheFile()
+	if file != "" {
+		if cached, err := readCachedCredentials(file); err == nil && cached.Expiration.After(p.now().Add(credentialsExpiryWindow)) {
+			logger.Log(LogInfo, "using cached credentials", map[string]interface{}{
+				"role_arn":   p.auth.RoleARN,
+				"expiration": cached.Expiration,
+			})
+			p.expiration = cached.Expiration
+			return cached.value(), nil
+		}
+	}
+
+	creds, err := p.request()
+	if err != nil {
+		return credentials.Value{}, err
+	}
+	if creds == nil {
+		return credentials.Value{}, errors.New("STS returned no credentials")
+	}
+	cached := cachedCredentials{
+		AccessKeyID:     aws.StringValue(creds.AccessKeyId),
+		SecretAccessKey: aws.StringValue(creds.SecretAccessKey),
+		SessionToken:    aws.StringValue(creds.SessionToken),
+		Expiration:      aws.TimeValue(creds.Expiration),
+	}
+	if file != "" {
+		if err := writeCachedCredentials(file, cached); err != nil {
+			logger.Log(LogInfo, "failed to cache credentials", map[string]interface{}{
+				"error": err.Error(),
+			})
+		}
+	}
+	p.expiration = cached.Expiration
+	return cached.value(), nil
+}
+
+// IsExpired returns whether the credentials need to be renewed
+func (p *stsProvider) IsExpired() bool {
+	return !p.now().Add(credentialsExpiryWindow).Before(p.expiration)
+}
+
+func (p *stsProvider) request() (*sts.Credentials, error) {
+	var serialNumber, tokenCode *string
+	if p.auth.MFASerial != "" {
+		if p.auth.TokenProvider == nil {
+			return nil, fmt.Errorf("a code of MFA device %s is required", p.auth.MFASerial)
+		}
+		code, err := p.auth.TokenProvider()
+		if err != nil {
+			return nil, err
+		}
+		serialNumber = aws.String(p.auth.MFASerial)
+		tokenCode = aws.String(code)
+	}
+
+	var durationSeconds *int64
+	if p.auth.SessionDuration > 0 {
+		durationSeconds = aws.Int64(int64(p.auth.SessionDuration / time.Second))
+	}
+
+	if p.auth.RoleARN == "" {
+		logger.Log(LogInfo, "getting session token", map[string]interface{}{
+			"mfa_serial": p.auth.MFASerial,
+		})
+		resp, err := p.svc.GetSessionToken(&sts.GetSessionTokenInput{
+			DurationSeconds: durationSeconds,
+			SerialNumber:    serialNumber,
+			TokenCode:       tokenCode,
+		})
+		if err != nil {
+			return nil, err
+		}
+		return resp.Credentials, nil
+	}
+
+	logger.Log(LogInfo, "assuming role", map[string]interface{}{
+		"role_arn":   p.auth.RoleARN,
+		"mfa_serial": p.auth.MFASerial,
+	})
+	input := &sts.AssumeRoleInput{
+		RoleArn:         aws.String(p.auth.RoleARN),
+		RoleSessionName: aws.String(fmt.Sprintf("chamber-%d", p.now().Unix())),
+		DurationSeconds: durationSeconds,
+		SerialNumber:    serialNumber,
+		TokenCode:       tokenCode,
+	}
+	if p.auth.ExternalID != "" {
+		input.ExternalId = aws.String(p.auth.ExternalID)
+	}
+	resp, err := p.svc.AssumeRole(input)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Credentials, nil
+}
+
+// cacheFile returns the file credentials for the auth options are cached in.
+// STS credentials are valid in every region, so the region is not part of the
+// cache key, but the credentials they were requested with are.
+func (p *stsProvider) cacheFile() string {
+	if p.auth.CacheDir == "" {
+		return ""
+	}
+	key := strings.Join([]string{
+		p.sourceIdentity(),
+		p.auth.RoleARN,
+		p.auth.ExternalID,
+		p.auth.MFASerial,
+		p.auth.SessionDuration.String(),
+	}, "|")
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(p.auth.CacheDir, hex.EncodeToString(sum[:16])+".json")
+}
+
+// sourceIdentity identifies the credentials temporary credentials are
+// requested with: the profile of the auth options, or else the profile or
+// access key of the environment
+func (p *stsProvider) sourceIdentity() string {
+	if p.auth.Profile != "" {
+		return "profile:" + p.auth.Profile
+	}
+	for _, name := range []string{"AWS_PROFILE", "AWS_DEFAULT_PROFILE"} {
+		if profile := os.Getenv(name); profile != "" {
+			return "profile:" + profile
+		}
+	}
+	return "access_key:" + os.Getenv("AWS_ACCESS_KEY_ID")
+}
+
+func (c cachedCredentials) value() credentials.Value {
+	return credentials.Value{
+		AccessKeyID:     c.AccessKeyID,
+		SecretAccessKey: c.SecretAccessKey,
+		SessionToken:    c.SessionToken,
+		ProviderName:    "chamber",
+	}
+}
+
+func readCachedCredentials(file string) (cachedCredentials, error) {
+	var cached cachedCredentials
+	contents, err := ioutil.ReadFile(file)
+	if err != nil {
+		return cached, err
+	}
+	err = json.Unmarshal(contents, &cached)
+	return cached, err
+}
+
+// writeCachedCredentials writes credentials to a file only readable by the
+// current user
+func writeCachedCredentials(file string, cached cachedCredentials) error {
+	if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		return err
+	}
+	contents, err := json.Marshal(cached)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, contents, 0600)
+}
@@ -0,0 +1,131 @@
+package store
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/stretchr/testify/assert"
+)
+
+type mockSTSClient struct {
+	assumeRoleInputs      []*sts.AssumeRoleInput
+	getSessionTokenInputs []*sts.GetSessionTokenInput
+	expiration            time.Time
+}
+
+func (m *mockSTSClient) credentials() *sts.Credentials {
+	return &sts.Credentials{
+		AccessKeyId:     aws.String("AKIA"),
+		SecretAccessKey: aws.String("secret"),
+		SessionToken:    aws.String("token"),
+		Expiration:      aws.Time(m.expiration),
+	}
+}
+
+func (m *mockSTSClient) AssumeRole(i *sts.AssumeRoleInput) (*sts.AssumeRoleOutput, error) {
+	m.assumeRoleInputs = append(m.assumeRoleInputs, i)
+	return &sts.AssumeRoleOutput{Credentials: m.credentials()}, nil
+}
+
+func (m *mockSTSClient) GetSessionToken(i *sts.GetSessionTokenInput) (*sts.GetSessionTokenOutput, error) {
+	m.getSessionTokenInputs = append(m.getSessionTokenInputs, i)
+	return &sts.GetSessionTokenOutput{Credentials: m.credentials()}, nil
+}
+
+func TestSTSProvider(t *testing.T) {
+	dir, err := ioutil.TempDir("", "chamber-credentials")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	now := time.Unix(1500000000, 0)
+	mock := &mockSTSClient{expiration: now.Add(time.Hour)}
+	auth := AuthOptions{
+		RoleARN:         "arn:aws:iam::123456789012:role/deploy",
+		ExternalID:      "external",
+		MFASerial:       "arn:aws:iam::123456789012:mfa/user",
+		SessionDuration: time.Hour,
+		TokenProvider:   func() (string, error) { return "123456", nil },
+		CacheDir:        dir,
+	}
+	newProvider := func(auth AuthOptions) *stsProvider {
+		return &stsProvider{svc: mock, auth: auth, now: func() time.Time { return now }}
+	}
+
+	t.Run("Roles should be assumed with the MFA code", func(t *testing.T) {
+		value, err := newProvider(auth).Retrieve()
+		assert.Nil(t, err)
+		assert.Equal(t, "AKIA", value.AccessKeyID)
+		assert.Equal(t, "token", value.SessionToken)
+
+		assert.Equal(t, 1, len(mock.assumeRoleInputs))
+		input := mock.assumeRoleInputs[0]
+		assert.Equal(t, auth.RoleARN, *input.RoleArn)
+		assert.Equal(t, "external", *input.ExternalId)
+		assert.Equal(t, auth.MFASerial, *input.SerialNumber)
+		assert.Equal(t, "123456", *input.TokenCode)
+		assert.Equal(t, int64(3600), *input.DurationSeconds)
+	})
+
+	t.Run("Cached credentials should be reused until they expire", func(t *testing.T) {
+		p := newProvider(auth)
+		_, err := p.Retrieve()
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(mock.assumeRoleInputs))
+		assert.False(t, p.IsExpired())
+
+		now = now.Add(56 * time.Minute)
+		assert.True(t, p.IsExpired())
+		mock.expiration = now.Add(time.Hour)
+		_, err = newProvider(auth).Retrieve()
+		assert.Nil(t, err)
+		assert.Equal(t, 2, len(mock.assumeRoleInputs))
+	})
+
+	t.Run("Cached credentials should only be readable by the user", func(t *testing.T) {
+		info, err := os.Stat(newProvider(auth).cacheFile())
+		assert.Nil(t, err)
+		assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	})
+
+	t.Run("Credentials should be cached per source identity, but not per region", func(t *testing.T) {
+		defer os.Setenv("AWS_PROFILE", os.Getenv("AWS_PROFILE"))
+		defer os.Setenv("AWS_ACCESS_KEY_ID", os.Getenv("AWS_ACCESS_KEY_ID"))
+		os.Unsetenv("AWS_PROFILE")
+		os.Unsetenv("AWS_DEFAULT_PROFILE")
+		os.Setenv("AWS_ACCESS_KEY_ID", "AKIAFIRST")
+		file := newProvider(auth).cacheFile()
+
+		inRegion := auth
+		inRegion.Region = "eu-west-1"
+		assert.Equal(t, file, newProvider(inRegion).cacheFile())
+
+		os.Setenv("AWS_ACCESS_KEY_ID", "AKIASECOND")
+		assert.NotEqual(t, file, newProvider(auth).cacheFile())
+
+		os.Setenv("AWS_PROFILE", "prod")
+		fromProfile := newProvider(auth).cacheFile()
+		assert.NotEqual(t, file, fromProfile)
+
+		os.Setenv("AWS_PROFILE", "dev")
+		assert.NotEqual(t, fromProfile, newProvider(auth).cacheFile())
+
+		withProfile := auth
+		withProfile.Profile = "prod"
+		assert.Equal(t, fromProfile, newProvider(withProfile).cacheFile())
+	})
+
+	t.Run("MFA without a role should get a session token", func(t *testing.T) {
+		_, err := newProvider(AuthOptions{
+			MFASerial:     auth.MFASerial,
+			TokenProvider: auth.TokenProvider,
+		}).Retrieve()
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(mock.getSessionTokenInputs))
+		assert.Equal(t, "123456", *mock.getSessionTokenInputs[0].TokenCode)
+		assert.Nil(t, mock.getSessionTokenInputs[0].DurationSeconds)
+	})
+}
@@ -0,0 +1,306 @@
+package store
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ssm"
+)
+
+// Values larger than a standard parameter can hold are split into chunks,
+// each stored in a parameter of its own next to the secret's parameter, which
+// holds a marker referencing them:
+//
+//   chamber:chunked:v1:<chunks>:<sha256 of the value>:<name of the secret>
+//
+// Chunk parameters are named after the secret they were written for and the
+// hash of the value, so that every version of a secret keeps its own chunks.
+// Chunks are kept as long as a version referencing them is in the history of
+// the secret, which SSM limits to the latest 100 versions, or in one of its
+// copies in the recycle bin.  The chunks of other versions are deleted when
+// the secret is deleted or purged, or when a large value is written to it.
+const (
+	// MaxValueSize is the largest value that fits a standard tier parameter
+	MaxValueSize = 4096
+
+	// MaxChunks is the largest number of chunks a value is split into
+	MaxChunks = 64
+
+	chunkedValuePrefix = "chamber:chunked:v1:"
+)
+
+// ErrValueTooLarge is returned when writing a value that is too large even
+// when split into chunks
+var ErrValueTooLarge = fmt.Errorf("value is larger than %d bytes", MaxValueSize*MaxChunks)
+
+// chunkValue splits value into chunks of at most MaxValueSize bytes, without
+// splitting multi-byte characters
+func chunkValue(value string) []string {
+	chunks := []string{}
+	for len(value) > MaxValueSize {
+		end := MaxValueSize
+		for end > 0 && !utf8.RuneStart(value[end]) {
+			end--
+		}
+		chunks = append(chunks, value[:end])
+		value = value[end:]
+	}
+	return append(chunks, value)
+}
+
+func valueHash(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:])
+}
+
+// chunkPrefix is the prefix of the names of the chunk parameters of the
+// parameter named name
+func (s *SSMStore) chunkPrefix(name string) string {
+	if s.usePaths {
+		return name + "/chunk-"
+	}
+	return name + ".chunk-"
+}
+
+func (s *SSMStore) chunkName(name, hash string, i int) string {
+	return fmt.Sprintf("%s%s-%d", s.chunkPrefix(name), hash[:16], i)
+}
+
+// writeChunks writes the chunks of value for the parameter described by
+// input, and returns the marker to store as its value
+func (s *SSMStore) writeChunks(input *ssm.PutParameterInput, value string) (string, error) {
+	chunks := chunkValue(value)
+	if len(chunks) > MaxChunks {
+		return "", ErrValueTooLarge
+	}
+
+	name := aws.StringValue(input.Name)
+	hash := valueHash(value)
+	for i, chunk := range chunks {
+		putParameterInput := &ssm.PutParameterInput{
+			KeyId:     input.KeyId,
+			Name:      aws.String(s.chunkName(name, hash, i)),
+			Type:      input.Type,
+			Value:     aws.String(chunk),
+			Overwrite: aws.Bool(true),
+		}
+		if _, err := s.svc.PutParameter(putParameterInput); err != nil {
+			return "", err
+		}
+	}
+	return fmt.Sprintf("%s%d:%s:%s", chunkedValuePrefix, len(chunks), hash, name), nil
+}
+
+// parseChunkedValue parses a chunked value marker into the number of chunks,
+// the hash of the value and the name of the parameter it was written for
+func parseChunkedValue(value string) (int, string, string, error) {
+	parts := strings.SplitN(strings.TrimPrefix(value, chunkedValuePrefix), ":", 3)
+	if !strings.HasPrefix(value, chunkedValuePrefix) || len(parts) != 3 {
+		return 0, "", "", errors.New("invalid chunked value")
+	}
+	count, err := strconv.Atoi(parts[0])
+	if err != nil || count < 1 || count > MaxChunks || len(parts[1]) != sha256.Size*2 {
+		return 0, "", "", errors.New("invalid chunked value")
+	}
+	return count, parts[1], parts[2], nil
+}
+
+// resolveValue reassembles the value referenced by a chunked value marker.
+// Other values are returned as is.
+func (s *SSMStore) resolveValue(value *string) (*string, error) {
+	if value == nil || !strings.HasPrefix(*value, chunkedValuePrefix) {
+		return value, nil
+	}
+
+	count, hash, name, err := parseChunkedValue(*value)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, count)
+	for i := range names {
+		names[i] = s.chunkName(name, hash, i)
+	}
+
+	chunks := map[string]string{}
+	for i := 0; i < len(names); i += 10 {
+		batchEnd := i + 10
+		if batchEnd > len(names) {
+			batchEnd = len(names)
+		}
+
+		getParametersInput := &ssm.GetParametersInput{
+			Names:          stringsToAWSStrings(names[i:batchEnd]),
+			WithDecryption: aws.Bool(true),
+		}
+		resp, err := s.svc.GetParameters(getParametersInput)
+		if err != nil {
+			return nil, err
+		}
+		for _, param := range resp.Parameters {
+			chunks[*param.Name] = *param.Value
+		}
+	}
+
+	var assembled []string
+	for _, chunkName := range names {
+		chunk, ok := chunks[chunkName]
+		if !ok {
+			return nil, fmt.Errorf("chunk %s of the value is missing", chunkName)
+		}
+		assembled = append(assembled, chunk)
+	}
+	resolved := strings.Join(assembled, "")
+	if valueHash(resolved) != hash {
+		return nil, errors.New("chunked value does not match its checksum")
+	}
+	return &resolved, nil
+}
+
+// deleteUnreferencedChunks deletes the chunk parameters of the secret id
+// that none of its versions reference anymore, neither in the history of the
+// secret nor in its copies in the recycle bin
+func (s *SSMStore) deleteUnreferencedChunks(id SecretId) error {
+	name := s.idToName(id)
+	chunkNames, err := s.listChunks(name)
+	if err != nil || len(chunkNames) == 0 {
+		return err
+	}
+
+	holders := []string{}
+	if _, err := s.readLatest(id); err == nil {
+		holders = append(holders, name)
+	} else if err != ErrSecretNotFound {
+		return err
+	}
+	deleted, err := s.listDeletedCopies(id)
+	if err != nil {
+		return err
+	}
+	holders = append(holders, deleted...)
+
+	referenced := map[string]bool{}
+	for _, holder := range holders {
+		values, err := s.rawValues(holder)
+		if err != nil {
+			return err
+		}
+		for _, value := range values {
+			if _, hash, chunkedName, err := parseChunkedValue(value); err == nil && chunkedName == name {
+				referenced[hash[:16]] = true
+			}
+		}
+	}
+
+	for _, chunkName := range chunkNames {
+		hash := strings.TrimPrefix(chunkName, s.chunkPrefix(name))
+		if i := strings.LastIndex(hash, "-"); i != -1 {
+			hash = hash[:i]
+		}
+		if referenced[hash] {
+			continue
+		}
+
+		logger.Log(LogDebug, "deleting unreferenced chunk", map[string]interface{}{
+			"name": chunkName,
+		})
+		deleteParameterInput := &ssm.DeleteParameterInput{
+			Name: aws.String(chunkName),
+		}
+		if _, err := s.svc.DeleteParameter(deleteParameterInput); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// listChunks returns the names of the chunk parameters of the parameter
+// named name
+func (s *SSMStore) listChunks(name string) ([]string, error) {
+	describeParametersInput := &ssm.DescribeParametersInput{
+		ParameterFilters: []*ssm.ParameterStringFilter{
+			{
+				Key:    aws.String("Name"),
+				Option: aws.String("BeginsWith"),
+				Values: []*string{aws.String(s.chunkPrefix(name))},
+			},
+		},
+	}
+
+	names := []string{}
+	if err := s.svc.DescribeParametersPages(describeParametersInput, func(o *ssm.DescribeParametersOutput, lastPage bool) bool {
+		for _, param := range o.Parameters {
+			names = append(names, *param.Name)
+		}
+		return !lastPage
+	}); err != nil {
+		return nil, err
+	}
+	return names, nil
+}
+
+// listDeletedCopies returns the names of the copies of the secret identified
+// by id in the recycle bin.  The deletion time comes first in their names, so
+// they are looked up by the id they end with rather than by listing the whole
+// recycle bin.
+func (s *SSMStore) listDeletedCopies(id SecretId) ([]string, error) {
+	suffix := fmt.Sprintf(".%s.%s", id.Service, id.Key)
+	if s.usePaths {
+		suffix = fmt.Sprintf("/%s/%s", id.Service, id.Key)
+	}
+	describeParametersInput := &ssm.DescribeParametersInput{
+		ParameterFilters: []*ssm.ParameterStringFilter{
+			{
+				Key:    aws.String("Name"),
+				Option: aws.String("Contains"),
+				Values: []*string{aws.String(suffix)},
+			},
+		},
+	}
+
+	names := []string{}
+	if err := s.svc.DescribeParametersPages(describeParametersInput, func(o *ssm.DescribeParametersOutput, lastPage bool) bool {
+		for _, param := range o.Parameters {
+			if d, ok := s.parseDeletedName(*param.Name); ok && d.Id == id {
+				names = append(names, *param.Name)
+			}
+		}
+		return !lastPage
+	}); err != nil {
+		return nil, err
+	}
+	return names, nil
+}
+
+// rawValues returns the values of every version of the parameter named name,
+// as stored, without reassembling chunked values
+func (s *SSMStore) rawValues(name string) ([]string, error) {
+	history, err := s.parameterHistory(name, true)
+	if err != nil {
+		return nil, err
+	}
+
+	getParametersInput := &ssm.GetParametersInput{
+		Names:          []*string{aws.String(name)},
+		WithDecryption: aws.Bool(true),
+	}
+	current, err := s.svc.GetParameters(getParametersInput)
+	if err != nil {
+		return nil, err
+	}
+
+	values := []string{}
+	for _, version := range history {
+		values = append(values, aws.StringValue(version.Value))
+	}
+	for _, param := range current.Parameters {
+		values = append(values, aws.StringValue(param.Value))
+	}
+	return values, nil
+}
@@ -0,0 +1,134 @@
+package store
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
+)
+
+// loggingSSMClient logs every request made to SSM, with the names of the
+// parameters involved, the number of pages read and how long it took
+type loggingSSMClient struct {
+	ssmiface.SSMAPI
+}
+
+func logRequest(api string, start time.Time, err error, fields map[string]interface{}) {
+	fields["api"] = api
+	fields["latency_ms"] = time.Since(start).Nanoseconds() / int64(time.Millisecond)
+	if err != nil {
+		fields["error"] = err.Error()
+	}
+	logger.Log(LogDebug, "ssm request", fields)
+}
+
+func (c loggingSSMClient) PutParameter(i *ssm.PutParameterInput) (*ssm.PutParameterOutput, error) {
+	start := time.Now()
+	o, err := c.SSMAPI.PutParameter(i)
+	logRequest("PutParameter", start, err, map[string]interface{}{
+		"name":    aws.StringValue(i.Name),
+		"type":    aws.StringValue(i.Type),
+		"kms_key": aws.StringValue(i.KeyId),
+	})
+	return o, err
+}
+
+func (c loggingSSMClient) GetParameters(i *ssm.GetParametersInput) (*ssm.GetParametersOutput, error) {
+	start := time.Now()
+	o, err := c.SSMAPI.GetParameters(i)
+	fields := map[string]interface{}{"names": aws.StringValueSlice(i.Names)}
+	if err == nil {
+		fields["found"] = len(o.Parameters)
+	}
+	logRequest("GetParameters", start, err, fields)
+	return o, err
+}
+
+func (c loggingSSMClient) GetParametersByPath(i *ssm.GetParametersByPathInput) (*ssm.GetParametersByPathOutput, error) {
+	start := time.Now()
+	o, err := c.SSMAPI.GetParametersByPath(i)
+	fields := map[string]interface{}{"path": aws.StringValue(i.Path)}
+	if err == nil {
+		fields["found"] = len(o.Parameters)
+		fields["last_page"] = o.NextToken == nil
+	}
+	logRequest("GetParametersByPath", start, err, fields)
+	return o, err
+}
+
+func (c loggingSSMClient) DescribeParameters(i *ssm.DescribeParametersInput) (*ssm.DescribeParametersOutput, error) {
+	start := time.Now()
+	o, err := c.SSMAPI.DescribeParameters(i)
+	fields := describeFilterFields(i)
+	if err == nil {
+		fields["found"] = len(o.Parameters)
+		fields["last_page"] = o.NextToken == nil
+	}
+	logRequest("DescribeParameters", start, err, fields)
+	return o, err
+}
+
+func (c loggingSSMClient) DescribeParametersPages(i *ssm.DescribeParametersInput, fn func(*ssm.DescribeParametersOutput, bool) bool) error {
+	start := time.Now()
+	pages, found := 0, 0
+	err := c.SSMAPI.DescribeParametersPages(i, func(o *ssm.DescribeParametersOutput, lastPage bool) bool {
+		pages++
+		found += len(o.Parameters)
+		return fn(o, lastPage)
+	})
+	fields := describeFilterFields(i)
+	fields["pages"] = pages
+	fields["found"] = found
+	logRequest("DescribeParameters", start, err, fields)
+	return err
+}
+
+func (c loggingSSMClient) GetParameterHistory(i *ssm.GetParameterHistoryInput) (*ssm.GetParameterHistoryOutput, error) {
+	start := time.Now()
+	o, err := c.SSMAPI.GetParameterHistory(i)
+	fields := map[string]interface{}{"name": aws.StringValue(i.Name)}
+	if err == nil {
+		fields["found"] = len(o.Parameters)
+		fields["last_page"] = o.NextToken == nil
+	}
+	logRequest("GetParameterHistory", start, err, fields)
+	return o, err
+}
+
+func (c loggingSSMClient) DeleteParameter(i *ssm.DeleteParameterInput) (*ssm.DeleteParameterOutput, error) {
+	start := time.Now()
+	o, err := c.SSMAPI.DeleteParameter(i)
+	logRequest("DeleteParameter", start, err, map[string]interface{}{"name": aws.StringValue(i.Name)})
+	return o, err
+}
+
+func (c loggingSSMClient) AddTagsToResource(i *ssm.AddTagsToResourceInput) (*ssm.AddTagsToResourceOutput, error) {
+	start := time.Now()
+	o, err := c.SSMAPI.AddTagsToResource(i)
+	logRequest("AddTagsToResource", start, err, map[string]interface{}{"name": aws.StringValue(i.ResourceId)})
+	return o, err
+}
+
+func (c loggingSSMClient) ListTagsForResource(i *ssm.ListTagsForResourceInput) (*ssm.ListTagsForResourceOutput, error) {
+	start := time.Now()
+	o, err := c.SSMAPI.ListTagsForResource(i)
+	logRequest("ListTagsForResource", start, err, map[string]interface{}{"name": aws.StringValue(i.ResourceId)})
+	return o, err
+}
+
+// describeFilterFields describes the filters of a DescribeParameters request
+func describeFilterFields(i *ssm.DescribeParametersInput) map[string]interface{} {
+	filters := []string{}
+	for _, filter := range i.Filters {
+		for _, value := range filter.Values {
+			filters = append(filters, aws.StringValue(filter.Key)+"="+aws.StringValue(value))
+		}
+	}
+	for _, filter := range i.ParameterFilters {
+		for _, value := range filter.Values {
+			filters = append(filters, aws.StringValue(filter.Key)+" "+aws.StringValue(filter.Option)+" "+aws.StringValue(value))
+		}
+	}
+	return map[string]interface{}{"filters": filters}
+}
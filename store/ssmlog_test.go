@@ -0,0 +1,40 @@
+package store
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type recordingLogger struct {
+	entries []string
+}
+
+func (l *recordingLogger) Log(level LogLevel, msg string, fields map[string]interface{}) {
+	l.entries = append(l.entries, fmt.Sprintf("%d %s %v", level, msg, fields))
+}
+
+func TestLoggingSSMClient(t *testing.T) {
+	recorder := &recordingLogger{}
+	SetLogger(recorder)
+	defer SetLogger(nil)
+
+	mock := &mockSSMClient{parameters: map[string]mockParameter{}}
+	store := NewTestSSMStoreWithPaths(mock)
+	store.svc = loggingSSMClient{mock}
+
+	secretId := SecretId{Service: "test", Key: "key"}
+	assert.Nil(t, store.Write(secretId, "super secret value", WriteOptions{}))
+	_, err := store.Read(secretId, -1)
+	assert.Nil(t, err)
+
+	all := strings.Join(recorder.entries, "\n")
+	assert.NotContains(t, all, "super secret value")
+	assert.Contains(t, all, "api:PutParameter")
+	assert.Contains(t, all, "api:GetParameters")
+	assert.Contains(t, all, "pages:1")
+	assert.Contains(t, all, "/test/key")
+	assert.Contains(t, all, "writing secret")
+}
@@ -0,0 +1,198 @@
+package store
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/request"
+)
+
+// maxRetryDelay is the longest delay before retrying a request
+const maxRetryDelay = 20 * time.Second
+
+// retryBaseDelays are the delays before the first retry of SSM APIs.  APIs
+// with lower rate limits back off longer.
+var retryBaseDelays = map[string]time.Duration{
+	"GetParameter":        100 * time.Millisecond,
+	"GetParameters":       100 * time.Millisecond,
+	"GetParametersByPath": 100 * time.Millisecond,
+	"DescribeParameters":  500 * time.Millisecond,
+	"GetParameterHistory": 300 * time.Millisecond,
+	"PutParameter":        300 * time.Millisecond,
+	"DeleteParameter":     300 * time.Millisecond,
+	"AddTagsToResource":   300 * time.Millisecond,
+	"ListTagsForResource": 300 * time.Millisecond,
+}
+
+const defaultRetryBaseDelay = 200 * time.Millisecond
+
+// RateLimiter is a token bucket limiting how many requests are made per
+// second.  It is safe for concurrent use.
+type RateLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+
+	now   func() time.Time
+	sleep func(time.Duration)
+}
+
+// NewRateLimiter creates a RateLimiter allowing rate requests per second on
+// average, and bursts of up to burst requests
+func NewRateLimiter(rate float64, burst int) *RateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &RateLimiter{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		now:    time.Now,
+		sleep:  time.Sleep,
+	}
+}
+
+// Wait blocks until a request can be made
+func (l *RateLimiter) Wait() {
+	l.mu.Lock()
+	now := l.now()
+	if !l.last.IsZero() {
+		l.tokens += now.Sub(l.last).Seconds() * l.rate
+		if l.tokens > l.burst {
+			l.tokens = l.burst
+		}
+	}
+	l.last = now
+
+	// Take the token now and wait for it to be refilled, so that waiting
+	// requests are served in turn
+	l.tokens--
+	var wait time.Duration
+	if l.tokens < 0 {
+		wait = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.mu.Unlock()
+
+	if wait > 0 {
+		l.sleep(wait)
+	}
+}
+
+// RetryStats counts the requests to SSM that were retried, per API.  It is
+// safe for concurrent use.
+type RetryStats struct {
+	mu        sync.Mutex
+	retries   map[string]int
+	throttles map[string]int
+}
+
+// NewRetryStats creates an empty RetryStats
+func NewRetryStats() *RetryStats {
+	return &RetryStats{
+		retries:   map[string]int{},
+		throttles: map[string]int{},
+	}
+}
+
+func (s *RetryStats) record(operation string, throttled bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.retries[operation]++
+	if throttled {
+		s.throttles[operation]++
+	}
+}
+
+// Retries returns the number of retries, and how many of them were caused by
+// throttling
+func (s *RetryStats) Retries() (retries int, throttles int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for operation, n := range s.retries {
+		retries += n
+		throttles += s.throttles[operation]
+	}
+	return retries, throttles
+}
+
+// Summary describes the retries of every API, one per line
+func (s *RetryStats) Summary() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	operations := make([]string, 0, len(s.retries))
+	for operation := range s.retries {
+		operations = append(operations, operation)
+	}
+	sort.Strings(operations)
+
+	lines := []string{}
+	for _, operation := range operations {
+		lines = append(lines, fmt.Sprintf("%s: %d retries, %d throttled", operation, s.retries[operation], s.throttles[operation]))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// ssmRetryer retries requests like the SDK's default retryer, with a
+// jittered exponential backoff tuned per API, and waits for the rate limiter
+// before every attempt
+type ssmRetryer struct {
+	client.DefaultRetryer
+
+	limiter *RateLimiter
+	stats   *RetryStats
+}
+
+// RetryRules returns how long to wait before retrying r
+func (r *ssmRetryer) RetryRules(req *request.Request) time.Duration {
+	operation := ""
+	if req.Operation != nil {
+		operation = req.Operation.Name
+	}
+	throttled := req.IsErrorThrottle()
+	if r.stats != nil {
+		r.stats.record(operation, throttled)
+	}
+	delay := retryDelay(operation, req.RetryCount, throttled)
+	logger.Log(LogInfo, "retrying request", map[string]interface{}{
+		"api":       operation,
+		"attempt":   req.RetryCount + 1,
+		"throttled": throttled,
+		"delay_ms":  delay.Nanoseconds() / int64(time.Millisecond),
+	})
+	return delay
+}
+
+// retryDelay returns the delay before the given retry of a request to the
+// given API, between half and all of an exponentially growing delay
+func retryDelay(operation string, retryCount int, throttled bool) time.Duration {
+	base, ok := retryBaseDelays[operation]
+	if !ok {
+		base = defaultRetryBaseDelay
+	}
+	if throttled {
+		base *= 2
+	}
+	if retryCount > 10 {
+		retryCount = 10
+	}
+
+	delay := base << uint(retryCount)
+	if delay > maxRetryDelay {
+		delay = maxRetryDelay
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// wait is a request handler waiting for the rate limiter, if any
+func (r *ssmRetryer) wait(req *request.Request) {
+	if r.limiter != nil {
+		r.limiter.Wait()
+	}
+}
@@ -0,0 +1,71 @@
+package store
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Unix(0, 0)
+	slept := time.Duration(0)
+	limiter := NewRateLimiter(10, 2)
+	limiter.now = func() time.Time { return now }
+	limiter.sleep = func(d time.Duration) {
+		slept += d
+		now = now.Add(d)
+	}
+
+	t.Run("Bursts should not wait", func(t *testing.T) {
+		limiter.Wait()
+		limiter.Wait()
+		assert.Equal(t, time.Duration(0), slept)
+	})
+
+	t.Run("Requests beyond the burst should wait for the rate", func(t *testing.T) {
+		limiter.Wait()
+		limiter.Wait()
+		assert.Equal(t, 200*time.Millisecond, slept)
+	})
+
+	t.Run("Tokens should be refilled over time up to the burst", func(t *testing.T) {
+		slept = 0
+		now = now.Add(time.Hour)
+		limiter.Wait()
+		limiter.Wait()
+		assert.Equal(t, time.Duration(0), slept)
+		limiter.Wait()
+		assert.Equal(t, 100*time.Millisecond, slept)
+	})
+}
+
+func TestRetryDelay(t *testing.T) {
+	for retry := 0; retry < 4; retry++ {
+		delay := retryDelay("GetParameters", retry, false)
+		max := 100 * time.Millisecond << uint(retry)
+		assert.True(t, delay >= max/2 && delay <= max)
+	}
+
+	t.Run("Throttled requests to slower APIs should back off longer", func(t *testing.T) {
+		delay := retryDelay("DescribeParameters", 0, true)
+		assert.True(t, delay >= 500*time.Millisecond && delay <= time.Second)
+	})
+
+	t.Run("Delays should be capped", func(t *testing.T) {
+		delay := retryDelay("DescribeParameters", 100, true)
+		assert.True(t, delay <= maxRetryDelay)
+	})
+}
+
+func TestRetryStats(t *testing.T) {
+	stats := NewRetryStats()
+	stats.record("GetParametersByPath", true)
+	stats.record("GetParametersByPath", false)
+	stats.record("DescribeParameters", true)
+
+	retries, throttles := stats.Retries()
+	assert.Equal(t, 3, retries)
+	assert.Equal(t, 2, throttles)
+	assert.Equal(t, "DescribeParameters: 1 retries, 1 throttled\nGetParametersByPath: 2 retries, 1 throttled", stats.Summary())
+}
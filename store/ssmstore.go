@@ -1,23 +1,54 @@
 package store
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/ec2metadata"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ssm"
 	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
+	"github.com/aws/aws-sdk-go/service/sts"
 )
 
 const (
 	// DefaultKeyID is the default alias for the KMS key used to encrypt/decrypt secrets
 	DefaultKeyID = "alias/parameter_store_key"
+
+	// DescriptionTagKey is the tag used to hold the human description of a
+	// secret, since the parameter description is used for the version number
+	DescriptionTagKey = "chamber:description"
+
+	// MovedFromTagKey is the tag recording the secret a moved secret came from
+	MovedFromTagKey = "chamber:moved-from"
+
+	// ReservedTagPrefix is the prefix of tag keys managed by chamber itself
+	ReservedTagPrefix = "chamber:"
+
+	// DeletedService is the reserved namespace soft deleted secrets are moved to
+	DeletedService = "_deleted"
+
+	// maxFilterValues is the largest number of values a DescribeParameters
+	// filter accepts
+	maxFilterValues = 50
+
+	// deletedTimeFormat is the format of the deletion timestamp in the names
+	// of soft deleted secrets, followed by nanoseconds so that deleting the
+	// same secret twice in a second doesn't collide.  Names written before
+	// nanoseconds were added are parsed with deletedSecondsFormat.
+	deletedTimeFormat    = "20060102T150405"
+	deletedSecondsFormat = "20060102T150405Z"
 )
 
 // validPathKeyFormat is the format that is expected for key names inside parameter store
@@ -28,31 +59,61 @@ var validPathKeyFormat = regexp.MustCompile(`^\/[A-Za-z0-9-_]+\/[A-Za-z0-9-_]+$`
 // not using paths
 var validKeyFormat = regexp.MustCompile(`^[A-Za-z0-9-_]+\.[A-Za-z0-9-_]+$`)
 
+// validTagFormat is the format that parameter store accepts for tag keys and values
+var validTagFormat = regexp.MustCompile(`^[\p{L}\p{Z}\p{N}_.:/=+\-@]*$`)
+
+// kmsKeyIDFormat is the format of KMS key IDs
+var kmsKeyIDFormat = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
+
 // ensure SSMStore confirms to Store interface
 var _ Store = &SSMStore{}
 
 // SSMStore implements the Store interface for storing secrets in SSM Parameter
 // Store
 type SSMStore struct {
-	svc      ssmiface.SSMAPI
-	usePaths bool
+	svc         ssmiface.SSMAPI
+	usePaths    bool
+	retryer     *ssmRetryer
+	kmsKeyAlias string
 }
 
-// NewSSMStore creates a new SSMStore
+// NewSSMStore creates a new SSMStore using the default credential chain
 func NewSSMStore(numRetries int) *SSMStore {
+	s, err := NewSSMStoreWithAuth(numRetries, AuthOptions{})
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+// NewSSMStoreWithAuth creates a new SSMStore using the credentials selected
+// by auth.  An error is returned if the AWS config can't be loaded, for
+// example if the profile does not exist.
+func NewSSMStoreWithAuth(numRetries int, auth AuthOptions) (*SSMStore, error) {
 	var region *string
+	regionSource := "AWS config"
 
 	if regionOverride, ok := os.LookupEnv("CHAMBER_AWS_REGION"); ok {
 		region = aws.String(regionOverride)
+		regionSource = "CHAMBER_AWS_REGION"
+	}
+	if auth.Region != "" {
+		region = aws.String(auth.Region)
+		regionSource = "store options"
 	}
-	ssmSession := session.Must(session.NewSessionWithOptions(
+	ssmSession, err := session.NewSessionWithOptions(
 		session.Options{
 			Config: aws.Config{
 				Region: region,
 			},
-			SharedConfigState: session.SharedConfigEnable,
+			Profile:                 auth.Profile,
+			SharedConfigState:       session.SharedConfigEnable,
+			AssumeRoleTokenProvider: auth.TokenProvider,
 		},
-	))
+	)
+	if err != nil {
+		return nil, err
+	}
 
 	// If region is still not set, attempt to determine it via ec2 metadata API
 	region = nil
@@ -61,11 +122,35 @@ func NewSSMStore(numRetries int) *SSMStore {
 		ec2metadataSvc := ec2metadata.New(session)
 		if regionOverride, err := ec2metadataSvc.Region(); err == nil {
 			region = aws.String(regionOverride)
+			regionSource = "EC2 instance metadata"
+		} else {
+			regionSource = "none"
 		}
 	}
-	svc := ssm.New(ssmSession, &aws.Config{
+	retryer := &ssmRetryer{
+		DefaultRetryer: client.DefaultRetryer{NumMaxRetries: numRetries},
+	}
+	config := &aws.Config{
 		MaxRetries: aws.Int(numRetries),
 		Region:     region,
+	}
+	if auth.RoleARN != "" || auth.MFASerial != "" {
+		config.Credentials = credentials.NewCredentials(&stsProvider{
+			svc:  sts.New(ssmSession, &aws.Config{Region: region}),
+			auth: auth,
+			now:  time.Now,
+		})
+	}
+	svc := ssm.New(ssmSession, request.WithRetryer(config, retryer))
+	svc.Handlers.Send.PushFront(retryer.wait)
+
+	resolvedRegion := aws.StringValue(region)
+	if region == nil {
+		resolvedRegion = aws.StringValue(ssmSession.Config.Region)
+	}
+	logger.Log(LogInfo, "resolved region", map[string]interface{}{
+		"region": resolvedRegion,
+		"source": regionSource,
 	})
 
 	usePaths := true
@@ -74,45 +159,145 @@ func NewSSMStore(numRetries int) *SSMStore {
 		usePaths = false
 	}
 
-	return &SSMStore{
+	s := &SSMStore{
 		svc:      svc,
 		usePaths: usePaths,
+		retryer:  retryer,
+	}
+	// Requests are only logged when a logger was set
+	if _, discard := logger.(nopLogger); !discard {
+		s.svc = loggingSSMClient{svc}
+	}
+	return s, nil
+}
+
+// SetRateLimiter makes every request to SSM, including retries, wait for
+// limiter.  Stores can share a limiter.
+func (s *SSMStore) SetRateLimiter(limiter *RateLimiter) {
+	s.retryer.limiter = limiter
+}
+
+// SetRetryStats makes the store count its retried requests in stats
+func (s *SSMStore) SetRetryStats(stats *RetryStats) {
+	s.retryer.stats = stats
+}
+
+// SetUsePaths sets whether secrets are named by path, instead of by
+// CHAMBER_NO_PATHS being unset
+func (s *SSMStore) SetUsePaths(usePaths bool) {
+	s.usePaths = usePaths
+}
+
+// SetKMSKeyAlias sets the KMS key secrets are encrypted with when no key is
+// given and CHAMBER_KMS_KEY_ALIAS is not set
+func (s *SSMStore) SetKMSKeyAlias(alias string) {
+	s.kmsKeyAlias = alias
+}
+
+// IsThrottled returns whether err is SSM rejecting a request because the
+// account's request rate was exceeded, once the client gave up retrying it
+func IsThrottled(err error) bool {
+	awsErr, ok := err.(awserr.Error)
+	if !ok {
+		return false
 	}
+	switch awsErr.Code() {
+	case "ThrottlingException", "Throttling", "TooManyUpdates":
+		return true
+	}
+	return false
 }
 
 func (s *SSMStore) KMSKey() string {
 	fromEnv, ok := os.LookupEnv("CHAMBER_KMS_KEY_ALIAS")
-	if !ok {
-		return DefaultKeyID
+	if ok {
+		return KMSKeyID(fromEnv)
 	}
-	if !strings.HasPrefix(fromEnv, "alias/") {
-		return fmt.Sprintf("alias/%s", fromEnv)
+	if s.kmsKeyAlias != "" {
+		return KMSKeyID(s.kmsKeyAlias)
 	}
+	return DefaultKeyID
+}
 
-	return fromEnv
+// KMSKeyID turns the name of a KMS key alias into the alias' ID.  Aliases
+// that are already prefixed with alias/, key IDs and ARNs are returned as is.
+func KMSKeyID(key string) string {
+	if strings.HasPrefix(key, "alias/") || strings.HasPrefix(key, "arn:") || kmsKeyIDFormat.MatchString(key) {
+		return key
+	}
+	return fmt.Sprintf("alias/%s", key)
 }
 
 // Write writes a given value to a secret identified by id.  If the secret
-// already exists, then write a new version.
-func (s *SSMStore) Write(id SecretId, value string) error {
+// already exists, then write a new version.  Any description or tags in opts
+// are attached to the secret after the value has been written.
+func (s *SSMStore) Write(id SecretId, value string, opts WriteOptions) error {
+	tags, err := optsToTags(opts)
+	if err != nil {
+		return err
+	}
+
 	version := 1
-	// first read to get the current version
-	current, err := s.Read(id, -1)
+	paramType := TypeSecureString
+	chunked := len(value) > MaxValueSize
+	// first read to get the current version and type
+	current, err := s.readLatest(id)
 	if err != nil && err != ErrSecretNotFound {
 		return err
 	}
 	if err == nil {
 		version = current.Meta.Version + 1
+		chunked = chunked || strings.HasPrefix(aws.StringValue(current.Value), chunkedValuePrefix)
+		if current.Meta.Type != "" {
+			paramType = current.Meta.Type
+		}
+	}
+	if opts.Type != "" {
+		paramType = opts.Type
 	}
 
 	putParameterInput := &ssm.PutParameterInput{
-		KeyId:       aws.String(s.KMSKey()),
 		Name:        aws.String(s.idToName(id)),
-		Type:        aws.String("SecureString"),
+		Type:        aws.String(paramType),
 		Value:       aws.String(value),
 		Overwrite:   aws.Bool(true),
 		Description: aws.String(strconv.Itoa(version)),
 	}
+	switch paramType {
+	case TypeSecureString:
+		putParameterInput.KeyId = aws.String(s.KMSKey())
+		if opts.DefaultKMSKey != "" {
+			putParameterInput.KeyId = aws.String(KMSKeyID(opts.DefaultKMSKey))
+		}
+		if opts.KMSKey != "" {
+			putParameterInput.KeyId = aws.String(KMSKeyID(opts.KMSKey))
+		}
+	case TypeString, TypeStringList:
+		if opts.KMSKey != "" {
+			return fmt.Errorf("a KMS key can only be used with %s secrets, %s is a %s", TypeSecureString, s.idToName(id), paramType)
+		}
+	default:
+		return fmt.Errorf("unsupported parameter type %s", paramType)
+	}
+
+	logger.Log(LogInfo, "writing secret", map[string]interface{}{
+		"name":    s.idToName(id),
+		"version": version,
+		"type":    paramType,
+		"kms_key": aws.StringValue(putParameterInput.KeyId),
+		"size":    len(value),
+	})
+
+	if strings.HasPrefix(value, chunkedValuePrefix) {
+		return fmt.Errorf("values starting with %s are reserved", chunkedValuePrefix)
+	}
+	if len(value) > MaxValueSize {
+		marker, err := s.writeChunks(putParameterInput, value)
+		if err != nil {
+			return err
+		}
+		putParameterInput.Value = aws.String(marker)
+	}
 
 	// This API call returns an empty struct
 	_, err = s.svc.PutParameter(putParameterInput)
@@ -120,24 +305,65 @@ func (s *SSMStore) Write(id SecretId, value string) error {
 		return err
 	}
 
+	// Delete the chunks of versions that dropped out of the history.  The
+	// value was written, so failing to do so only leaves chunks behind.
+	if chunked {
+		if err := s.deleteUnreferencedChunks(id); err != nil {
+			logger.Log(LogInfo, "failed to delete unreferenced chunks", map[string]interface{}{
+				"name":  s.idToName(id),
+				"error": err.Error(),
+			})
+		}
+	}
+
+	if len(tags) == 0 {
+		return nil
+	}
+
+	addTagsToResourceInput := &ssm.AddTagsToResourceInput{
+		ResourceId:   aws.String(s.idToName(id)),
+		ResourceType: aws.String("Parameter"),
+		Tags:         tags,
+	}
+
+	_, err = s.svc.AddTagsToResource(addTagsToResourceInput)
+	if err != nil {
+		return err
+	}
+
 	return nil
 }
 
 // Read reads a secret from the parameter store at a specific version.
-// To grab the latest version, use -1 as the version number.
+// To grab the latest version, use -1 as the version number.  The returned
+// metadata includes the description and tags attached to the secret.
 func (s *SSMStore) Read(id SecretId, version int) (Secret, error) {
+	var secret Secret
+	var err error
 	if version == -1 {
-		return s.readLatest(id)
+		secret, err = s.readLatest(id)
+	} else {
+		secret, err = s.readVersion(id, version)
+	}
+	if err != nil {
+		return Secret{}, err
 	}
 
-	return s.readVersion(id, version)
+	if secret.Value, err = s.resolveValue(secret.Value); err != nil {
+		return Secret{}, err
+	}
+
+	if err := s.readTags(s.idToName(id), &secret.Meta); err != nil {
+		return Secret{}, err
+	}
+	return secret, nil
 }
 
 // Delete removes a secret from the parameter store. Note this removes all
 // versions of the secret.
 func (s *SSMStore) Delete(id SecretId) error {
 	// first read to ensure parameter present
-	_, err := s.Read(id, -1)
+	_, err := s.readLatest(id)
 	if err != nil {
 		return err
 	}
@@ -151,21 +377,306 @@ func (s *SSMStore) Delete(id SecretId) error {
 		return err
 	}
 
+	// Chunks referenced by copies of the secret in the recycle bin are kept
+	return s.deleteUnreferencedChunks(id)
+}
+
+// SoftDelete moves a secret, including all of its versions and tags, to the
+// recycle bin, from where it can be restored with Undelete.
+func (s *SSMStore) SoftDelete(id SecretId) error {
+	// first read to ensure parameter present
+	_, err := s.readLatest(id)
+	if err != nil {
+		return err
+	}
+
+	deleted := s.deletedName(DeletedSecret{Id: id, DeletedAt: time.Now().UTC()})
+	if err := s.copyParameter(s.idToName(id), deleted); err != nil {
+		return err
+	}
+
+	deleteParameterInput := &ssm.DeleteParameterInput{
+		Name: aws.String(s.idToName(id)),
+	}
+
+	_, err = s.svc.DeleteParameter(deleteParameterInput)
+	if err != nil {
+		return err
+	}
+
 	return nil
 }
 
-func (s *SSMStore) readVersion(id SecretId, version int) (Secret, error) {
-	getParameterHistoryInput := &ssm.GetParameterHistoryInput{
-		Name:           aws.String(s.idToName(id)),
+// Undelete restores the most recently soft deleted copy of a secret,
+// including all of its versions and tags.  Restoring over a secret that
+// exists returns ErrSecretExists.
+func (s *SSMStore) Undelete(id SecretId) error {
+	all, err := s.ListDeleted()
+	if err != nil {
+		return err
+	}
+
+	var latest *DeletedSecret
+	for i, deleted := range all {
+		if deleted.Id != id {
+			continue
+		}
+		if latest == nil || deleted.DeletedAt.After(latest.DeletedAt) {
+			latest = &all[i]
+		}
+	}
+	if latest == nil {
+		return ErrSecretNotFound
+	}
+
+	_, err = s.readLatest(id)
+	if err == nil {
+		return ErrSecretExists
+	}
+	if err != ErrSecretNotFound {
+		return err
+	}
+
+	if err := s.copyParameter(s.deletedName(*latest), s.idToName(id)); err != nil {
+		return err
+	}
+
+	return s.Purge(*latest)
+}
+
+// ListDeleted lists the secrets in the recycle bin
+func (s *SSMStore) ListDeleted() ([]DeletedSecret, error) {
+	prefix := DeletedService + "."
+	if s.usePaths {
+		prefix = "/" + DeletedService + "/"
+	}
+
+	describeParametersInput := &ssm.DescribeParametersInput{
+		ParameterFilters: []*ssm.ParameterStringFilter{
+			{
+				Key:    aws.String("Name"),
+				Option: aws.String("BeginsWith"),
+				Values: []*string{aws.String(prefix)},
+			},
+		},
+		MaxResults: aws.Int64(50),
+	}
+
+	deleted := []DeletedSecret{}
+	if err := s.svc.DescribeParametersPages(describeParametersInput, func(o *ssm.DescribeParametersOutput, lastPage bool) bool {
+		for _, param := range o.Parameters {
+			if d, ok := s.parseDeletedName(*param.Name); ok {
+				deleted = append(deleted, d)
+			}
+		}
+		return !lastPage
+	}); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(deleted, func(i, j int) bool {
+		return deleted[i].DeletedAt.Before(deleted[j].DeletedAt)
+	})
+	return deleted, nil
+}
+
+// Purge permanently removes a secret from the recycle bin
+func (s *SSMStore) Purge(deleted DeletedSecret) error {
+	deleteParameterInput := &ssm.DeleteParameterInput{
+		Name: aws.String(s.deletedName(deleted)),
+	}
+
+	_, err := s.svc.DeleteParameter(deleteParameterInput)
+	if err != nil {
+		return err
+	}
+
+	// Chunks of large values stay with the secret they were written for, and
+	// are only deleted once no copy of that secret references them anymore
+	return s.deleteUnreferencedChunks(deleted.Id)
+}
+
+// copyParameter replays every version of the parameter named from onto the
+// parameter named to, preserving the version numbers, and copies its tags.
+// If any version can't be copied, the parameter named to is deleted again
+// and an error is returned, so that the copy is never incomplete.
+func (s *SSMStore) copyParameter(from, to string) error {
+	copied, err := s.copyVersions(from, to)
+	if err != nil {
+		if copied > 0 {
+			deleteParameterInput := &ssm.DeleteParameterInput{
+				Name: aws.String(to),
+			}
+			if _, deleteErr := s.svc.DeleteParameter(deleteParameterInput); deleteErr != nil {
+				logger.Log(LogInfo, "failed to delete incomplete copy", map[string]interface{}{
+					"name":  to,
+					"error": deleteErr.Error(),
+				})
+			}
+		}
+		return err
+	}
+	return nil
+}
+
+// copyVersions does the work of copyParameter, and returns the number of
+// versions written to the parameter named to
+func (s *SSMStore) copyVersions(from, to string) (int, error) {
+	copied := 0
+	history, err := s.parameterHistory(from, true)
+	if err != nil {
+		if isParameterNotFound(err) {
+			return copied, ErrSecretNotFound
+		}
+		return copied, err
+	}
+
+	for _, version := range history {
+		if err := s.putVersion(to, version.Type, version.KeyId, version.Value, version.Description); err != nil {
+			return copied, err
+		}
+		copied++
+	}
+
+	// The current version is not included in the GetParameterHistory
+	// response, and its description has to be looked up separately
+	getParametersInput := &ssm.GetParametersInput{
+		Names:          []*string{aws.String(from)},
 		WithDecryption: aws.Bool(true),
 	}
 
-	resp, err := s.svc.GetParameterHistory(getParameterHistoryInput)
+	current, err := s.svc.GetParameters(getParametersInput)
+	if err != nil {
+		return copied, err
+	}
+	if len(current.Parameters) == 0 {
+		return copied, ErrSecretNotFound
+	}
+
+	describeParametersInput := &ssm.DescribeParametersInput{
+		ParameterFilters: []*ssm.ParameterStringFilter{
+			{
+				Key:    aws.String("Name"),
+				Option: aws.String("BeginsWith"),
+				Values: []*string{aws.String(from)},
+			},
+		},
+	}
+
+	var meta *ssm.ParameterMetadata
+	if err := s.svc.DescribeParametersPages(describeParametersInput, func(o *ssm.DescribeParametersOutput, lastPage bool) bool {
+		for _, param := range o.Parameters {
+			if *param.Name == from {
+				meta = param
+			}
+		}
+		return !lastPage
+	}); err != nil {
+		return copied, err
+	}
+	if meta == nil {
+		return copied, ErrSecretNotFound
+	}
+
+	param := current.Parameters[0]
+	if err := s.putVersion(to, param.Type, meta.KeyId, param.Value, meta.Description); err != nil {
+		return copied, err
+	}
+	copied++
+
+	// Check that every version made it, the current one not being part of
+	// the history
+	copiedHistory, err := s.parameterHistory(to, false)
+	if err != nil {
+		return copied, err
+	}
+	if len(copiedHistory) != len(history) {
+		return copied, fmt.Errorf("only %d of the %d versions of %s were copied", len(copiedHistory)+1, len(history)+1, from)
+	}
+
+	listTagsForResourceInput := &ssm.ListTagsForResourceInput{
+		ResourceId:   aws.String(from),
+		ResourceType: aws.String("Parameter"),
+	}
+
+	tags, err := s.svc.ListTagsForResource(listTagsForResourceInput)
+	if err != nil {
+		return copied, err
+	}
+	if len(tags.TagList) == 0 {
+		return copied, nil
+	}
+
+	addTagsToResourceInput := &ssm.AddTagsToResourceInput{
+		ResourceId:   aws.String(to),
+		ResourceType: aws.String("Parameter"),
+		Tags:         tags.TagList,
+	}
+
+	_, err = s.svc.AddTagsToResource(addTagsToResourceInput)
+	return copied, err
+}
+
+// parameterHistory returns the versions of the parameter named name that
+// GetParameterHistory returns, following every page
+func (s *SSMStore) parameterHistory(name string, withDecryption bool) ([]*ssm.ParameterHistory, error) {
+	getParameterHistoryInput := &ssm.GetParameterHistoryInput{
+		Name:           aws.String(name),
+		WithDecryption: aws.Bool(withDecryption),
+		MaxResults:     aws.Int64(50),
+	}
+
+	history := []*ssm.ParameterHistory{}
+	for {
+		resp, err := s.svc.GetParameterHistory(getParameterHistoryInput)
+		if err != nil {
+			return nil, err
+		}
+		history = append(history, resp.Parameters...)
+
+		if resp.NextToken == nil {
+			return history, nil
+		}
+		getParameterHistoryInput.NextToken = resp.NextToken
+	}
+}
+
+// isParameterNotFound returns whether err is SSM reporting that a parameter
+// does not exist
+func isParameterNotFound(err error) bool {
+	awsErr, ok := err.(awserr.Error)
+	return ok && awsErr.Code() == "ParameterNotFound"
+}
+
+// putVersion writes a single version of a parameter, keeping the given
+// description (and thus version number).  The store's default KMS key is used
+// if keyID is nil, and no key is used for types other than SecureString.
+func (s *SSMStore) putVersion(name string, paramType, keyID, value, description *string) error {
+	if aws.StringValue(paramType) != TypeSecureString {
+		keyID = nil
+	} else if keyID == nil {
+		keyID = aws.String(s.KMSKey())
+	}
+	putParameterInput := &ssm.PutParameterInput{
+		KeyId:       keyID,
+		Name:        aws.String(name),
+		Type:        paramType,
+		Value:       value,
+		Overwrite:   aws.Bool(true),
+		Description: description,
+	}
+
+	_, err := s.svc.PutParameter(putParameterInput)
+	return err
+}
+
+func (s *SSMStore) readVersion(id SecretId, version int) (Secret, error) {
+	versions, err := s.parameterHistory(s.idToName(id), true)
 	if err != nil {
 		return Secret{}, ErrSecretNotFound
 	}
 
-	for _, history := range resp.Parameters {
+	for _, history := range versions {
 		thisVersion := 0
 		if history.Description != nil {
 			thisVersion, _ = strconv.Atoi(*history.Description)
@@ -178,6 +689,8 @@ func (s *SSMStore) readVersion(id SecretId, version int) (Secret, error) {
 					CreatedBy: *history.LastModifiedUser,
 					Version:   thisVersion,
 					Key:       *history.Name,
+					KMSKey:    aws.StringValue(history.KeyId),
+					Type:      aws.StringValue(history.Type),
 				},
 			}, nil
 		}
@@ -211,93 +724,219 @@ func (s *SSMStore) readLatest(id SecretId) (Secret, error) {
 		return Secret{}, ErrSecretNotFound
 	}
 	param := resp.Parameters[0]
-	var parameter *ssm.ParameterMetadata
-	var describeParametersInput *ssm.DescribeParametersInput
 
 	// To get metadata, we need to use describe parameters
+	metas, err := s.describeNames([]string{s.idToName(id)})
+	if err != nil {
+		return Secret{}, err
+	}
+	parameter, ok := metas[s.idToName(id)]
+	if !ok {
+		return Secret{}, ErrSecretNotFound
+	}
+
+	secretMeta := parameterMetaToSecretMeta(parameter)
+
+	return Secret{
+		Value: param.Value,
+		Meta:  secretMeta,
+	}, nil
+}
+
+// List lists all secrets for a given service.  If includeValues is true,
+// then those secrets are decrypted and returned, otherwise only the metadata
+// about a secret is returned.  If includeTags is true, the description and
+// tags of each secret are also returned, at the cost of an extra API call
+// per secret.
+func (s *SSMStore) List(service string, includeValues bool, includeTags bool) ([]Secret, error) {
+	var describeParametersInput *ssm.DescribeParametersInput
 
 	if s.usePaths {
-		// There is no way to use describe parameters to get a single key
-		// if that key uses paths, so instead get all the keys for a path,
-		// then find the one you are looking for :(
 		describeParametersInput = &ssm.DescribeParametersInput{
 			ParameterFilters: []*ssm.ParameterStringFilter{
 				{
 					Key:    aws.String("Path"),
 					Option: aws.String("OneLevel"),
-					Values: []*string{aws.String(basePath(s.idToName(id)))},
+					Values: []*string{aws.String("/" + service)},
 				},
 			},
+			MaxResults: aws.Int64(50),
 		}
 	} else {
 		describeParametersInput = &ssm.DescribeParametersInput{
 			Filters: []*ssm.ParametersFilter{
 				{
 					Key:    aws.String("Name"),
-					Values: []*string{aws.String(s.idToName(id))},
+					Values: []*string{aws.String(service + ".")},
 				},
 			},
-			MaxResults: aws.Int64(1),
-		}
-	}
-	if err := s.svc.DescribeParametersPages(describeParametersInput, func(o *ssm.DescribeParametersOutput, lastPage bool) bool {
-		for _, param := range o.Parameters {
-			if *param.Name == s.idToName(id) {
-				parameter = param
-			}
+			MaxResults: aws.Int64(50),
 		}
-		return !lastPage
-	}); err != nil {
-		return Secret{}, err
 	}
 
-	if parameter == nil {
-		return Secret{}, ErrSecretNotFound
+	secrets, err := s.describeSecrets(describeParametersInput)
+	if err != nil {
+		return nil, err
 	}
 
-	secretMeta := parameterMetaToSecretMeta(parameter)
+	if includeValues {
+		if err := s.readValues(secrets); err != nil {
+			return nil, err
+		}
+	}
 
-	return Secret{
-		Value: param.Value,
-		Meta:  secretMeta,
-	}, nil
+	if includeTags {
+		for name, secret := range secrets {
+			if err := s.readTags(name, &secret.Meta); err != nil {
+				return nil, err
+			}
+			secrets[name] = secret
+		}
+	}
+	return values(secrets), nil
 }
 
-// List lists all secrets for a given service.  If includeValues is true,
-// then those secrets are decrypted and returned, otherwise only the metadata
-// about a secret is returned.
-func (s *SSMStore) List(service string, includeValues bool) ([]Secret, error) {
-	secrets := map[string]Secret{}
-
-	var nextToken *string
-	for {
-		var describeParametersInput *ssm.DescribeParametersInput
+// ListAll lists the secrets of every service whose name begins with
+// servicePrefix.  An empty prefix lists the secrets of all services.  If
+// includeValues is true, then those secrets are decrypted and returned.
+func (s *SSMStore) ListAll(servicePrefix string, includeValues bool) ([]Secret, error) {
+	describeParametersInput := &ssm.DescribeParametersInput{
+		MaxResults: aws.Int64(50),
+	}
 
+	if servicePrefix != "" {
 		if s.usePaths {
-			describeParametersInput = &ssm.DescribeParametersInput{
-				ParameterFilters: []*ssm.ParameterStringFilter{
-					{
-						Key:    aws.String("Path"),
-						Option: aws.String("OneLevel"),
-						Values: []*string{aws.String("/" + service)},
-					},
+			describeParametersInput.ParameterFilters = []*ssm.ParameterStringFilter{
+				{
+					Key:    aws.String("Name"),
+					Option: aws.String("BeginsWith"),
+					Values: []*string{aws.String("/" + servicePrefix)},
 				},
-				MaxResults: aws.Int64(50),
-				NextToken:  nextToken,
 			}
 		} else {
-			describeParametersInput = &ssm.DescribeParametersInput{
-				Filters: []*ssm.ParametersFilter{
-					{
-						Key:    aws.String("Name"),
-						Values: []*string{aws.String(service + ".")},
-					},
+			describeParametersInput.Filters = []*ssm.ParametersFilter{
+				{
+					Key:    aws.String("Name"),
+					Values: []*string{aws.String(servicePrefix)},
 				},
-				MaxResults: aws.Int64(50),
-				NextToken:  nextToken,
 			}
 		}
+	}
 
+	secrets, err := s.describeSecrets(describeParametersInput)
+	if err != nil {
+		return nil, err
+	}
+
+	if includeValues {
+		if err := s.readValues(secrets); err != nil {
+			return nil, err
+		}
+	}
+	return values(secrets), nil
+}
+
+// ListServices lists every service whose name begins with servicePrefix,
+// along with the number of keys it holds and when any of them was last
+// modified.  Services are returned sorted by name.
+func (s *SSMStore) ListServices(servicePrefix string) ([]ServiceSummary, error) {
+	secrets, err := s.ListAll(servicePrefix, false)
+	if err != nil {
+		return nil, err
+	}
+
+	summaries := map[string]ServiceSummary{}
+	for _, secret := range secrets {
+		service := s.nameToId(secret.Meta.Key).Service
+		summary := summaries[service]
+		summary.Service = service
+		summary.Keys++
+		if secret.Meta.Created.After(summary.LastModified) {
+			summary.LastModified = secret.Meta.Created
+		}
+		summaries[service] = summary
+	}
+
+	services := []ServiceSummary{}
+	for _, summary := range summaries {
+		services = append(services, summary)
+	}
+	sort.Slice(services, func(i, j int) bool {
+		return services[i].Service < services[j].Service
+	})
+	return services, nil
+}
+
+// ReadMany reads the latest version of the secrets identified by ids.
+// Secrets that do not exist are missing from the returned map.  Unlike Read,
+// the description and tags of the secrets are not returned.
+func (s *SSMStore) ReadMany(ids []SecretId) (map[SecretId]Secret, error) {
+	names := make([]string, len(ids))
+	for i, id := range ids {
+		names[i] = s.idToName(id)
+	}
+
+	metas, err := s.describeNames(names)
+	if err != nil {
+		return nil, err
+	}
+	secrets := make(map[string]Secret, len(metas))
+	for name, meta := range metas {
+		secrets[name] = Secret{Meta: parameterMetaToSecretMeta(meta)}
+	}
+	if err := s.readValues(secrets); err != nil {
+		return nil, err
+	}
+
+	read := make(map[SecretId]Secret, len(secrets))
+	for _, id := range ids {
+		// Secrets deleted between the two calls have no value
+		if secret, ok := secrets[s.idToName(id)]; ok && secret.Value != nil {
+			read[id] = secret
+		}
+	}
+	return read, nil
+}
+
+// describeNames returns the metadata of the parameters with the given names,
+// keyed by name.  Parameters are looked up by name in batches, rather than by
+// listing the services they belong to.
+func (s *SSMStore) describeNames(names []string) (map[string]*ssm.ParameterMetadata, error) {
+	metas := map[string]*ssm.ParameterMetadata{}
+	for i := 0; i < len(names); i += maxFilterValues {
+		batchEnd := i + maxFilterValues
+		if batchEnd > len(names) {
+			batchEnd = len(names)
+		}
+
+		describeParametersInput := &ssm.DescribeParametersInput{
+			ParameterFilters: []*ssm.ParameterStringFilter{
+				{
+					Key:    aws.String("Name"),
+					Option: aws.String("Equals"),
+					Values: stringsToAWSStrings(names[i:batchEnd]),
+				},
+			},
+			MaxResults: aws.Int64(50),
+		}
+		if err := s.svc.DescribeParametersPages(describeParametersInput, func(o *ssm.DescribeParametersOutput, lastPage bool) bool {
+			for _, param := range o.Parameters {
+				metas[*param.Name] = param
+			}
+			return !lastPage
+		}); err != nil {
+			return nil, err
+		}
+	}
+	return metas, nil
+}
+
+// describeSecrets pages through the parameters matching the given input and
+// returns the metadata of those that are valid chamber secrets, keyed by name
+func (s *SSMStore) describeSecrets(describeParametersInput *ssm.DescribeParametersInput) (map[string]Secret, error) {
+	secrets := map[string]Secret{}
+
+	for {
 		resp, err := s.svc.DescribeParameters(describeParametersInput)
 		if err != nil {
 			return nil, err
@@ -318,36 +957,42 @@ func (s *SSMStore) List(service string, includeValues bool) ([]Secret, error) {
 			break
 		}
 
-		nextToken = resp.NextToken
+		describeParametersInput.NextToken = resp.NextToken
 	}
 
-	if includeValues {
-		secretKeys := keys(secrets)
-		for i := 0; i < len(secretKeys); i += 10 {
-			batchEnd := i + 10
-			if i+10 > len(secretKeys) {
-				batchEnd = len(secretKeys)
-			}
-			batch := secretKeys[i:batchEnd]
+	return secrets, nil
+}
 
-			getParametersInput := &ssm.GetParametersInput{
-				Names:          stringsToAWSStrings(batch),
-				WithDecryption: aws.Bool(true),
-			}
+// readValues decrypts and fills in the values of the given secrets, in
+// batches of the maximum size GetParameters supports
+func (s *SSMStore) readValues(secrets map[string]Secret) error {
+	secretKeys := keys(secrets)
+	for i := 0; i < len(secretKeys); i += 10 {
+		batchEnd := i + 10
+		if i+10 > len(secretKeys) {
+			batchEnd = len(secretKeys)
+		}
+		batch := secretKeys[i:batchEnd]
 
-			resp, err := s.svc.GetParameters(getParametersInput)
-			if err != nil {
-				return nil, err
-			}
+		getParametersInput := &ssm.GetParametersInput{
+			Names:          stringsToAWSStrings(batch),
+			WithDecryption: aws.Bool(true),
+		}
 
-			for _, param := range resp.Parameters {
-				secret := secrets[*param.Name]
-				secret.Value = param.Value
-				secrets[*param.Name] = secret
+		resp, err := s.svc.GetParameters(getParametersInput)
+		if err != nil {
+			return err
+		}
+
+		for _, param := range resp.Parameters {
+			secret := secrets[*param.Name]
+			if secret.Value, err = s.resolveValue(param.Value); err != nil {
+				return err
 			}
+			secrets[*param.Name] = secret
 		}
 	}
-	return values(secrets), nil
+	return nil
 }
 
 // ListRaw lists all secrets keys and values for a given service. Does not include any
@@ -379,6 +1024,10 @@ func (s *SSMStore) ListRaw(service string) ([]RawSecret, error) {
 								"This is behavior deprecated and will be removed in a future version of chamber. Please update your IAM permissions to grant ssm:GetParametersByPath.\n\n",
 							awsErr)
 
+						logger.Log(LogInfo, "falling back to DescribeParameters", map[string]interface{}{
+							"service": service,
+							"reason":  awsErr.Code(),
+						})
 						// Delegate to List
 						return s.listRawViaList(service)
 					}
@@ -392,9 +1041,14 @@ func (s *SSMStore) ListRaw(service string) ([]RawSecret, error) {
 					continue
 				}
 
+				value, err := s.resolveValue(param.Value)
+				if err != nil {
+					return nil, err
+				}
 				secrets[*param.Name] = RawSecret{
-					Value: *param.Value,
+					Value: *value,
 					Key:   *param.Name,
+					Type:  aws.StringValue(param.Type),
 				}
 			}
 
@@ -424,17 +1078,12 @@ func (s *SSMStore) ListRaw(service string) ([]RawSecret, error) {
 func (s *SSMStore) History(id SecretId) ([]ChangeEvent, error) {
 	events := []ChangeEvent{}
 
-	getParameterHistoryInput := &ssm.GetParameterHistoryInput{
-		Name:           aws.String(s.idToName(id)),
-		WithDecryption: aws.Bool(false),
-	}
-
-	resp, err := s.svc.GetParameterHistory(getParameterHistoryInput)
+	versions, err := s.parameterHistory(s.idToName(id), false)
 	if err != nil {
 		return events, ErrSecretNotFound
 	}
 
-	for _, history := range resp.Parameters {
+	for _, history := range versions {
 		// Disregard error here, if Atoi fails (secret created outside of
 		// Chamber), then we use version 0
 		version := 0
@@ -450,7 +1099,7 @@ func (s *SSMStore) History(id SecretId) ([]ChangeEvent, error) {
 	}
 
 	// The current version is not included in the GetParameterHistory response
-	current, err := s.Read(id, -1)
+	current, err := s.readLatest(id)
 	if err != nil {
 		return events, err
 	}
@@ -464,9 +1113,56 @@ func (s *SSMStore) History(id SecretId) ([]ChangeEvent, error) {
 	return events, nil
 }
 
+// Versions reads every version of a secret, oldest first.  Unlike reading
+// versions one at a time, the history is only fetched once.  Like ReadMany,
+// the description and tags of the secret are not returned.
+func (s *SSMStore) Versions(id SecretId) ([]Secret, error) {
+	history, err := s.parameterHistory(s.idToName(id), true)
+	if err != nil {
+		if isParameterNotFound(err) {
+			return nil, ErrSecretNotFound
+		}
+		return nil, err
+	}
+
+	// The current version is not included in the GetParameterHistory response
+	current, err := s.readLatest(id)
+	if err != nil {
+		return nil, err
+	}
+
+	versions := []Secret{}
+	for _, version := range history {
+		thisVersion := 0
+		if version.Description != nil {
+			thisVersion, _ = strconv.Atoi(*version.Description)
+		}
+		value, err := s.resolveValue(version.Value)
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, Secret{
+			Value: value,
+			Meta: SecretMetadata{
+				Created:   *version.LastModifiedDate,
+				CreatedBy: *version.LastModifiedUser,
+				Version:   thisVersion,
+				Key:       *version.Name,
+				KMSKey:    aws.StringValue(version.KeyId),
+				Type:      aws.StringValue(version.Type),
+			},
+		})
+	}
+
+	if current.Value, err = s.resolveValue(current.Value); err != nil {
+		return nil, err
+	}
+	return append(versions, current), nil
+}
+
 func (s *SSMStore) listRawViaList(service string) ([]RawSecret, error) {
 	// Delegate to List
-	secrets, err := s.List(service, true)
+	secrets, err := s.List(service, true, false)
 
 	if err != nil {
 		return nil, err
@@ -480,12 +1176,47 @@ func (s *SSMStore) listRawViaList(service string) ([]RawSecret, error) {
 			// This dereference is safe because we trust List to have given us the values
 			// that we asked-for
 			Value: *secret.Value,
+			Type:  secret.Meta.Type,
 		}
 	}
 
 	return rawSecrets, nil
 }
 
+// readTags fills in the description and tags of the parameter with the given
+// name.  Users whose IAM permissions don't grant ssm:ListTagsForResource get
+// no tags and TagsDenied set rather than an error, so that reading secrets
+// keeps working for them.
+func (s *SSMStore) readTags(name string, meta *SecretMetadata) error {
+	listTagsForResourceInput := &ssm.ListTagsForResourceInput{
+		ResourceId:   aws.String(name),
+		ResourceType: aws.String("Parameter"),
+	}
+
+	meta.Tags = map[string]string{}
+	resp, err := s.svc.ListTagsForResource(listTagsForResourceInput)
+	if err != nil {
+		if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == "AccessDeniedException" {
+			logger.Log(LogInfo, "not reading tags", map[string]interface{}{
+				"name":   name,
+				"reason": awsErr.Code(),
+			})
+			meta.TagsDenied = true
+			return nil
+		}
+		return err
+	}
+
+	for _, tag := range resp.TagList {
+		if *tag.Key == DescriptionTagKey {
+			meta.Description = *tag.Value
+			continue
+		}
+		meta.Tags[*tag.Key] = *tag.Value
+	}
+	return nil
+}
+
 func (s *SSMStore) idToName(id SecretId) string {
 	if s.usePaths {
 		return fmt.Sprintf("/%s/%s", id.Service, id.Key)
@@ -494,19 +1225,86 @@ func (s *SSMStore) idToName(id SecretId) string {
 	return fmt.Sprintf("%s.%s", id.Service, id.Key)
 }
 
-func (s *SSMStore) validateName(name string) bool {
+func (s *SSMStore) deletedName(deleted DeletedSecret) string {
+	deletedAt := deleted.DeletedAt.UTC()
+	timestamp := fmt.Sprintf("%s%09dZ", deletedAt.Format(deletedTimeFormat), deletedAt.Nanosecond())
 	if s.usePaths {
-		return validPathKeyFormat.MatchString(name)
+		return fmt.Sprintf("/%s/%s/%s/%s", DeletedService, timestamp, deleted.Id.Service, deleted.Id.Key)
 	}
-	return validKeyFormat.MatchString(name)
+
+	return fmt.Sprintf("%s.%s.%s.%s", DeletedService, timestamp, deleted.Id.Service, deleted.Id.Key)
 }
 
-func basePath(key string) string {
-	pathParts := strings.Split(key, "/")
-	if len(pathParts) == 1 {
-		return pathParts[0]
+// parseDeletedName is the inverse of deletedName
+func (s *SSMStore) parseDeletedName(name string) (DeletedSecret, bool) {
+	var tokens []string
+	if s.usePaths {
+		tokens = strings.Split(strings.TrimPrefix(name, "/"), "/")
+	} else {
+		tokens = strings.Split(name, ".")
 	}
-	return "/" + pathParts[1]
+	if len(tokens) != 4 || tokens[0] != DeletedService {
+		return DeletedSecret{}, false
+	}
+
+	deletedAt, ok := parseDeletedTime(tokens[1])
+	if !ok {
+		return DeletedSecret{}, false
+	}
+
+	return DeletedSecret{
+		Id: SecretId{
+			Service: tokens[2],
+			Key:     tokens[3],
+		},
+		DeletedAt: deletedAt,
+	}, true
+}
+
+// parseDeletedTime parses the deletion timestamp of the name of a soft deleted
+// secret
+func parseDeletedTime(timestamp string) (time.Time, bool) {
+	if deletedAt, err := time.Parse(deletedSecondsFormat, timestamp); err == nil {
+		return deletedAt, true
+	}
+
+	seconds := len(deletedTimeFormat)
+	if len(timestamp) != seconds+10 || !strings.HasSuffix(timestamp, "Z") {
+		return time.Time{}, false
+	}
+	deletedAt, err := time.Parse(deletedTimeFormat, timestamp[:seconds])
+	if err != nil {
+		return time.Time{}, false
+	}
+	nanoseconds, err := strconv.Atoi(timestamp[seconds : seconds+9])
+	if err != nil || nanoseconds < 0 {
+		return time.Time{}, false
+	}
+	return deletedAt.Add(time.Duration(nanoseconds)), true
+}
+
+// nameToId is the inverse of idToName, for names that pass validateName
+func (s *SSMStore) nameToId(name string) SecretId {
+	if s.usePaths {
+		tokens := strings.Split(name, "/")
+		return SecretId{
+			Service: tokens[1],
+			Key:     tokens[2],
+		}
+	}
+
+	tokens := strings.Split(name, ".")
+	return SecretId{
+		Service: tokens[0],
+		Key:     tokens[1],
+	}
+}
+
+func (s *SSMStore) validateName(name string) bool {
+	if s.usePaths {
+		return validPathKeyFormat.MatchString(name)
+	}
+	return validKeyFormat.MatchString(name)
 }
 
 func parameterMetaToSecretMeta(p *ssm.ParameterMetadata) SecretMetadata {
@@ -519,7 +1317,35 @@ func parameterMetaToSecretMeta(p *ssm.ParameterMetadata) SecretMetadata {
 		CreatedBy: *p.LastModifiedUser,
 		Version:   version,
 		Key:       *p.Name,
+		KMSKey:    aws.StringValue(p.KeyId),
+		Type:      aws.StringValue(p.Type),
+	}
+}
+
+// optsToTags converts the description and tags of opts into parameter store
+// tags, validating that parameter store will accept them
+func optsToTags(opts WriteOptions) ([]*ssm.Tag, error) {
+	tags := []*ssm.Tag{}
+	for k, v := range opts.Tags {
+		if k == DescriptionTagKey {
+			return nil, fmt.Errorf("tag key '%s' is reserved for the description", k)
+		}
+		if len(k) == 0 || len(k) > 128 || !validTagFormat.MatchString(k) {
+			return nil, fmt.Errorf("invalid tag key '%s'", k)
+		}
+		if len(v) > 256 || !validTagFormat.MatchString(v) {
+			return nil, fmt.Errorf("invalid value for tag '%s'", k)
+		}
+		tags = append(tags, &ssm.Tag{Key: aws.String(k), Value: aws.String(v)})
+	}
+
+	if opts.Description != "" {
+		if len(opts.Description) > 256 || !validTagFormat.MatchString(opts.Description) {
+			return nil, errors.New("invalid description: only letters, numbers, spaces and _.:/=+-@ are allowed, up to 256 characters")
+		}
+		tags = append(tags, &ssm.Tag{Key: aws.String(DescriptionTagKey), Value: aws.String(opts.Description)})
 	}
+	return tags, nil
 }
 
 func keys(m map[string]Secret) []string {
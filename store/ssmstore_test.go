@@ -2,13 +2,16 @@ package store
 
 import (
 	"errors"
+	"fmt"
 	"os"
 	"sort"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/ssm"
 	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
 	"github.com/stretchr/testify/assert"
@@ -17,12 +20,26 @@ import (
 type mockSSMClient struct {
 	ssmiface.SSMAPI
 	parameters map[string]mockParameter
+	// described counts the parameters returned by DescribeParameters
+	described int
+	// denyTags makes ListTagsForResource fail like it does for users
+	// without the IAM permission
+	denyTags bool
+	// historyPageSize is the number of versions per page returned by
+	// GetParameterHistory, all of them if zero
+	historyPageSize int
+	// historyErr is returned by GetParameterHistory if set
+	historyErr error
+	// historyLimit is the number of versions kept per parameter, including
+	// the current one, all of them if zero
+	historyLimit int
 }
 
 type mockParameter struct {
 	currentParam *ssm.Parameter
 	history      []*ssm.ParameterHistory
 	meta         *ssm.ParameterMetadata
+	tags         map[string]string
 }
 
 func (m *mockSSMClient) PutParameter(i *ssm.PutParameterInput) (*ssm.PutParameterOutput, error) {
@@ -30,6 +47,7 @@ func (m *mockSSMClient) PutParameter(i *ssm.PutParameterInput) (*ssm.PutParamete
 	if !ok {
 		current = mockParameter{
 			history: []*ssm.ParameterHistory{},
+			tags:    map[string]string{},
 		}
 	}
 
@@ -44,6 +62,9 @@ func (m *mockSSMClient) PutParameter(i *ssm.PutParameterInput) (*ssm.PutParamete
 			Value:            current.currentParam.Value,
 		}
 		current.history = append(current.history, history)
+		if m.historyLimit > 0 && len(current.history) >= m.historyLimit {
+			current.history = current.history[len(current.history)-m.historyLimit+1:]
+		}
 	}
 
 	current.currentParam = &ssm.Parameter{
@@ -92,6 +113,29 @@ func (m *mockSSMClient) GetParameters(i *ssm.GetParametersInput) (*ssm.GetParame
 }
 
 func (m *mockSSMClient) GetParameterHistory(i *ssm.GetParameterHistoryInput) (*ssm.GetParameterHistoryOutput, error) {
+	if m.historyErr != nil {
+		return &ssm.GetParameterHistoryOutput{}, m.historyErr
+	}
+	output, err := m.getParameterHistory(i)
+	if err != nil || m.historyPageSize == 0 {
+		return output, err
+	}
+
+	start := 0
+	if i.NextToken != nil {
+		start, _ = strconv.Atoi(*i.NextToken)
+	}
+	end := start + m.historyPageSize
+	if end >= len(output.Parameters) {
+		output.Parameters = output.Parameters[start:]
+		return output, nil
+	}
+	output.Parameters = output.Parameters[start:end]
+	output.NextToken = aws.String(strconv.Itoa(end))
+	return output, nil
+}
+
+func (m *mockSSMClient) getParameterHistory(i *ssm.GetParameterHistoryInput) (*ssm.GetParameterHistoryOutput, error) {
 	history := []*ssm.ParameterHistory{}
 
 	param, ok := m.parameters[*i.Name]
@@ -143,6 +187,7 @@ func (m *mockSSMClient) DescribeParameters(i *ssm.DescribeParametersInput) (*ssm
 			parameters = append(parameters, param.meta)
 		}
 	}
+	m.described += len(parameters)
 
 	return &ssm.DescribeParametersOutput{
 		Parameters: parameters,
@@ -193,6 +238,38 @@ func (m *mockSSMClient) DeleteParameter(i *ssm.DeleteParameterInput) (*ssm.Delet
 	return &ssm.DeleteParameterOutput{}, nil
 }
 
+func (m *mockSSMClient) AddTagsToResource(i *ssm.AddTagsToResourceInput) (*ssm.AddTagsToResourceOutput, error) {
+	param, ok := m.parameters[*i.ResourceId]
+	if !ok {
+		return &ssm.AddTagsToResourceOutput{}, errors.New("invalid resource id")
+	}
+
+	for _, tag := range i.Tags {
+		param.tags[*tag.Key] = *tag.Value
+	}
+
+	return &ssm.AddTagsToResourceOutput{}, nil
+}
+
+func (m *mockSSMClient) ListTagsForResource(i *ssm.ListTagsForResourceInput) (*ssm.ListTagsForResourceOutput, error) {
+	if m.denyTags {
+		return &ssm.ListTagsForResourceOutput{}, awserr.New("AccessDeniedException", "not authorized to perform: ssm:ListTagsForResource", nil)
+	}
+	param, ok := m.parameters[*i.ResourceId]
+	if !ok {
+		return &ssm.ListTagsForResourceOutput{}, errors.New("invalid resource id")
+	}
+
+	tags := []*ssm.Tag{}
+	for k, v := range param.tags {
+		tags = append(tags, &ssm.Tag{Key: aws.String(k), Value: aws.String(v)})
+	}
+
+	return &ssm.ListTagsForResourceOutput{
+		TagList: tags,
+	}, nil
+}
+
 func paramNameInSlice(name *string, slice []*string) bool {
 	for _, val := range slice {
 		if *val == *name {
@@ -261,15 +338,21 @@ func matchStringFilters(filters []*ssm.ParameterStringFilter, param mockParamete
 			}
 
 		case "Name":
-			if *filter.Option == "BeginsWith" {
-				result := false
-				for _, value := range filter.Values {
-					if strings.HasPrefix(*param.meta.Name, *value) {
-						result = true
-					}
+			result := false
+			for _, value := range filter.Values {
+				switch *filter.Option {
+				case "BeginsWith":
+					result = result || strings.HasPrefix(*param.meta.Name, *value)
+				case "Equals":
+					result = result || *param.meta.Name == *value
+				case "Contains":
+					result = result || strings.Contains(*param.meta.Name, *value)
+				default:
+					return false, errors.New("invalid filter option")
 				}
-
-				return result, nil
+			}
+			if !result {
+				return false, nil
 			}
 		}
 	}
@@ -312,7 +395,7 @@ func TestWrite(t *testing.T) {
 
 	t.Run("Setting a new key should work", func(t *testing.T) {
 		secretId := SecretId{Service: "test", Key: "mykey"}
-		err := store.Write(secretId, "value")
+		err := store.Write(secretId, "value", WriteOptions{})
 		assert.Nil(t, err)
 		assert.Contains(t, mock.parameters, store.idToName(secretId))
 		assert.Equal(t, "value", *mock.parameters[store.idToName(secretId)].currentParam.Value)
@@ -321,9 +404,9 @@ func TestWrite(t *testing.T) {
 
 	t.Run("Setting a key twice should create a new version", func(t *testing.T) {
 		secretId := SecretId{Service: "test", Key: "multipleversions"}
-		err := store.Write(secretId, "value")
+		err := store.Write(secretId, "value", WriteOptions{})
 		assert.Nil(t, err)
-		err = store.Write(secretId, "newvalue")
+		err = store.Write(secretId, "newvalue", WriteOptions{})
 		assert.Nil(t, err)
 
 		assert.Contains(t, mock.parameters, store.idToName(secretId))
@@ -333,13 +416,301 @@ func TestWrite(t *testing.T) {
 	})
 }
 
+func TestWriteKMSKey(t *testing.T) {
+	mock := &mockSSMClient{parameters: map[string]mockParameter{}}
+	store := NewTestSSMStoreWithPaths(mock)
+	secretId := SecretId{Service: "pci", Key: "card_key"}
+
+	t.Run("The default key should be used without an override", func(t *testing.T) {
+		err := store.Write(secretId, "value", WriteOptions{})
+		assert.Nil(t, err)
+		assert.Equal(t, DefaultKeyID, *mock.parameters[store.idToName(secretId)].meta.KeyId)
+	})
+
+	t.Run("The key given in the options should be used", func(t *testing.T) {
+		err := store.Write(secretId, "value", WriteOptions{KMSKey: "pci_key"})
+		assert.Nil(t, err)
+		assert.Equal(t, "alias/pci_key", *mock.parameters[store.idToName(secretId)].meta.KeyId)
+
+		s, err := store.Read(secretId, -1)
+		assert.Nil(t, err)
+		assert.Equal(t, "alias/pci_key", s.Meta.KMSKey)
+	})
+
+	t.Run("The default key given in the options should only be used without a key", func(t *testing.T) {
+		err := store.Write(secretId, "value", WriteOptions{DefaultKMSKey: "service_key"})
+		assert.Nil(t, err)
+		assert.Equal(t, "alias/service_key", *mock.parameters[store.idToName(secretId)].meta.KeyId)
+
+		err = store.Write(secretId, "value", WriteOptions{KMSKey: "pci_key", DefaultKMSKey: "service_key"})
+		assert.Nil(t, err)
+		assert.Equal(t, "alias/pci_key", *mock.parameters[store.idToName(secretId)].meta.KeyId)
+	})
+
+	t.Run("Soft deleting should keep the key of every version", func(t *testing.T) {
+		assert.Nil(t, store.SoftDelete(secretId))
+		assert.Nil(t, store.Undelete(secretId))
+		param := mock.parameters[store.idToName(secretId)]
+		assert.Equal(t, DefaultKeyID, *param.history[0].KeyId)
+		assert.Equal(t, "alias/pci_key", *param.meta.KeyId)
+	})
+
+	t.Run("The alias set on the store should be the default key", func(t *testing.T) {
+		store.SetKMSKeyAlias("project_key")
+		defer store.SetKMSKeyAlias("")
+		assert.Equal(t, "alias/project_key", store.KMSKey())
+
+		os.Setenv("CHAMBER_KMS_KEY_ALIAS", "env_key")
+		defer os.Unsetenv("CHAMBER_KMS_KEY_ALIAS")
+		assert.Equal(t, "alias/env_key", store.KMSKey())
+	})
+
+	t.Run("Key IDs and ARNs should not be prefixed", func(t *testing.T) {
+		assert.Equal(t, "alias/pci_key", KMSKeyID("alias/pci_key"))
+		assert.Equal(t, "1234abcd-12ab-34cd-56ef-1234567890ab", KMSKeyID("1234abcd-12ab-34cd-56ef-1234567890ab"))
+		assert.Equal(t, "arn:aws:kms:us-west-2:111122223333:alias/pci_key", KMSKeyID("arn:aws:kms:us-west-2:111122223333:alias/pci_key"))
+	})
+}
+
+func TestWriteTypes(t *testing.T) {
+	mock := &mockSSMClient{parameters: map[string]mockParameter{}}
+	store := NewTestSSMStoreWithPaths(mock)
+	secretId := SecretId{Service: "config", Key: "hostname"}
+
+	t.Run("String secrets should be written without a KMS key", func(t *testing.T) {
+		err := store.Write(secretId, "db.example.com", WriteOptions{Type: TypeString})
+		assert.Nil(t, err)
+		param := mock.parameters[store.idToName(secretId)]
+		assert.Equal(t, TypeString, *param.meta.Type)
+		assert.Nil(t, param.meta.KeyId)
+
+		s, err := store.Read(secretId, -1)
+		assert.Nil(t, err)
+		assert.Equal(t, TypeString, s.Meta.Type)
+	})
+
+	t.Run("The type of an existing secret should be kept", func(t *testing.T) {
+		err := store.Write(secretId, "db2.example.com", WriteOptions{})
+		assert.Nil(t, err)
+		assert.Equal(t, TypeString, *mock.parameters[store.idToName(secretId)].meta.Type)
+
+		rawSecrets, err := store.ListRaw("config")
+		assert.Nil(t, err)
+		assert.Equal(t, TypeString, rawSecrets[0].Type)
+	})
+
+	t.Run("KMS keys should be rejected for non SecureString secrets", func(t *testing.T) {
+		err := store.Write(secretId, "db3.example.com", WriteOptions{KMSKey: "pci_key"})
+		assert.NotNil(t, err)
+	})
+
+	t.Run("Default KMS keys should be ignored for non SecureString secrets", func(t *testing.T) {
+		err := store.Write(secretId, "db3.example.com", WriteOptions{DefaultKMSKey: "pci_key"})
+		assert.Nil(t, err)
+		param := mock.parameters[store.idToName(secretId)]
+		assert.Equal(t, TypeString, *param.meta.Type)
+		assert.Nil(t, param.meta.KeyId)
+	})
+
+	t.Run("Unknown types should be rejected", func(t *testing.T) {
+		err := store.Write(secretId, "db3.example.com", WriteOptions{Type: "Binary"})
+		assert.NotNil(t, err)
+	})
+}
+
+func TestWriteLargeValues(t *testing.T) {
+	large := strings.Repeat("ünïcödé certificate data ", 500)
+
+	for name, store := range map[string]*SSMStore{
+		"without paths": NewTestSSMStore(&mockSSMClient{parameters: map[string]mockParameter{}}),
+		"with paths":    NewTestSSMStoreWithPaths(&mockSSMClient{parameters: map[string]mockParameter{}}),
+	} {
+		mock := store.svc.(*mockSSMClient)
+		secretId := SecretId{Service: "certs", Key: "tls_key"}
+
+		t.Run("Large values should be split into chunks "+name, func(t *testing.T) {
+			err := store.Write(secretId, large, WriteOptions{})
+			assert.Nil(t, err)
+			for paramName, param := range mock.parameters {
+				assert.True(t, len(*param.currentParam.Value) <= MaxValueSize, paramName)
+			}
+			assert.True(t, len(mock.parameters) > 1)
+
+			s, err := store.Read(secretId, -1)
+			assert.Nil(t, err)
+			assert.Equal(t, large, *s.Value)
+		})
+
+		t.Run("Chunked values should be reassembled when listing "+name, func(t *testing.T) {
+			rawSecrets, err := store.ListRaw("certs")
+			assert.Nil(t, err)
+			assert.Equal(t, 1, len(rawSecrets))
+			assert.Equal(t, large, rawSecrets[0].Value)
+
+			secrets, err := store.List("certs", true, false)
+			assert.Nil(t, err)
+			assert.Equal(t, 1, len(secrets))
+			assert.Equal(t, large, *secrets[0].Value)
+		})
+
+		t.Run("Older versions should keep their chunks "+name, func(t *testing.T) {
+			err := store.Write(secretId, "small", WriteOptions{})
+			assert.Nil(t, err)
+
+			first, err := store.Read(secretId, 1)
+			assert.Nil(t, err)
+			assert.Equal(t, large, *first.Value)
+		})
+
+		t.Run("Chunks should survive soft deletes "+name, func(t *testing.T) {
+			assert.Nil(t, store.SoftDelete(secretId))
+			assert.Nil(t, store.Undelete(secretId))
+
+			first, err := store.Read(secretId, 1)
+			assert.Nil(t, err)
+			assert.Equal(t, large, *first.Value)
+		})
+
+		t.Run("Deleting should delete the chunks "+name, func(t *testing.T) {
+			assert.Nil(t, store.Delete(secretId))
+			assert.Equal(t, 0, len(mock.parameters))
+		})
+
+		t.Run("Values larger than the maximum number of chunks should be rejected "+name, func(t *testing.T) {
+			err := store.Write(secretId, strings.Repeat("x", MaxValueSize*MaxChunks+1), WriteOptions{})
+			assert.Equal(t, ErrValueTooLarge, err)
+		})
+	}
+}
+
+func TestLargeValueChunkRetention(t *testing.T) {
+	first := strings.Repeat("first certificate ", 500)
+	second := strings.Repeat("second certificate ", 500)
+	secretId := SecretId{Service: "certs", Key: "tls_key"}
+
+	// chunkSets counts the chunk sets, as the distinct hashes in the names
+	// of chunk parameters
+	chunkSets := func(store *SSMStore) int {
+		names, err := store.listChunks(store.idToName(secretId))
+		assert.Nil(t, err)
+		hashes := map[string]bool{}
+		for _, name := range names {
+			hash := strings.TrimPrefix(name, store.chunkPrefix(store.idToName(secretId)))
+			hashes[hash[:16]] = true
+		}
+		return len(hashes)
+	}
+
+	for name, newStore := range map[string]func(ssmiface.SSMAPI) *SSMStore{
+		"without paths": NewTestSSMStore,
+		"with paths":    NewTestSSMStoreWithPaths,
+	} {
+		t.Run("Purging an older copy should keep the chunks of newer ones "+name, func(t *testing.T) {
+			store := newStore(&mockSSMClient{parameters: map[string]mockParameter{}})
+
+			assert.Nil(t, store.Write(secretId, first, WriteOptions{}))
+			assert.Nil(t, store.SoftDelete(secretId))
+			assert.Nil(t, store.Write(secretId, second, WriteOptions{}))
+			assert.Nil(t, store.SoftDelete(secretId))
+			assert.Equal(t, 2, chunkSets(store))
+
+			deleted, err := store.ListDeleted()
+			assert.Nil(t, err)
+			assert.Equal(t, 2, len(deleted))
+			assert.Nil(t, store.Purge(deleted[0]))
+			assert.Equal(t, 1, chunkSets(store))
+
+			assert.Nil(t, store.Undelete(secretId))
+			s, err := store.Read(secretId, -1)
+			assert.Nil(t, err)
+			assert.Equal(t, second, *s.Value)
+		})
+
+		t.Run("Deleting should keep the chunks of copies in the recycle bin "+name, func(t *testing.T) {
+			store := newStore(&mockSSMClient{parameters: map[string]mockParameter{}})
+
+			assert.Nil(t, store.Write(secretId, first, WriteOptions{}))
+			assert.Nil(t, store.SoftDelete(secretId))
+			assert.Nil(t, store.Write(secretId, second, WriteOptions{}))
+			assert.Nil(t, store.Delete(secretId))
+			assert.Equal(t, 1, chunkSets(store))
+
+			assert.Nil(t, store.Undelete(secretId))
+			s, err := store.Read(secretId, -1)
+			assert.Nil(t, err)
+			assert.Equal(t, first, *s.Value)
+
+			assert.Nil(t, store.Delete(secretId))
+			assert.Equal(t, 0, chunkSets(store))
+		})
+
+		t.Run("Writing should delete the chunks of versions no longer in the history "+name, func(t *testing.T) {
+			mock := &mockSSMClient{parameters: map[string]mockParameter{}, historyLimit: 3}
+			store := newStore(mock)
+
+			values := []string{first, second, strings.Repeat("third certificate ", 500), strings.Repeat("fourth certificate ", 500)}
+			for i, value := range values {
+				assert.Nil(t, store.Write(secretId, value, WriteOptions{}))
+				expected := i + 1
+				if expected > mock.historyLimit {
+					expected = mock.historyLimit
+				}
+				assert.Equal(t, expected, chunkSets(store))
+			}
+
+			s, err := store.Read(secretId, 2)
+			assert.Nil(t, err)
+			assert.Equal(t, second, *s.Value)
+
+			_, err = store.Read(secretId, 1)
+			assert.NotNil(t, err)
+		})
+	}
+}
+
+func TestWriteTags(t *testing.T) {
+	mock := &mockSSMClient{parameters: map[string]mockParameter{}}
+	store := NewTestSSMStoreWithPaths(mock)
+	secretId := SecretId{Service: "test", Key: "tagged"}
+
+	t.Run("Writing with a description and tags should tag the parameter", func(t *testing.T) {
+		err := store.Write(secretId, "value", WriteOptions{
+			Description: "Payments database password",
+			Tags:        map[string]string{"owner": "payments"},
+		})
+		assert.Nil(t, err)
+		tags := mock.parameters[store.idToName(secretId)].tags
+		assert.Equal(t, "payments", tags["owner"])
+		assert.Equal(t, "Payments database password", tags[DescriptionTagKey])
+	})
+
+	t.Run("Writing without tags should preserve existing tags", func(t *testing.T) {
+		err := store.Write(secretId, "newvalue", WriteOptions{})
+		assert.Nil(t, err)
+		s, err := store.Read(secretId, -1)
+		assert.Nil(t, err)
+		assert.Equal(t, "Payments database password", s.Meta.Description)
+		assert.Equal(t, map[string]string{"owner": "payments"}, s.Meta.Tags)
+	})
+
+	t.Run("Writing invalid tags should fail without writing", func(t *testing.T) {
+		invalidId := SecretId{Service: "test", Key: "invalid"}
+		err := store.Write(invalidId, "value", WriteOptions{Tags: map[string]string{"owner": "pay,ments"}})
+		assert.NotNil(t, err)
+		assert.NotContains(t, mock.parameters, store.idToName(invalidId))
+
+		err = store.Write(invalidId, "value", WriteOptions{Tags: map[string]string{DescriptionTagKey: "nope"}})
+		assert.NotNil(t, err)
+	})
+}
+
 func TestRead(t *testing.T) {
 	mock := &mockSSMClient{parameters: map[string]mockParameter{}}
 	store := NewTestSSMStore(mock)
 	secretId := SecretId{Service: "test", Key: "key"}
-	store.Write(secretId, "value")
-	store.Write(secretId, "second value")
-	store.Write(secretId, "third value")
+	store.Write(secretId, "value", WriteOptions{})
+	store.Write(secretId, "second value", WriteOptions{})
+	store.Write(secretId, "third value", WriteOptions{})
 
 	t.Run("Reading the latest value should work", func(t *testing.T) {
 		s, err := store.Read(secretId, -1)
@@ -370,6 +741,17 @@ func TestRead(t *testing.T) {
 		_, err := store.Read(secretId, 30)
 		assert.Equal(t, ErrSecretNotFound, err)
 	})
+
+	t.Run("Reading without permission to list tags should return no tags", func(t *testing.T) {
+		mock.denyTags = true
+		defer func() { mock.denyTags = false }()
+
+		s, err := store.Read(secretId, -1)
+		assert.Nil(t, err)
+		assert.Equal(t, "third value", *s.Value)
+		assert.Equal(t, map[string]string{}, s.Meta.Tags)
+		assert.True(t, s.Meta.TagsDenied)
+	})
 }
 
 func TestList(t *testing.T) {
@@ -382,11 +764,11 @@ func TestList(t *testing.T) {
 		{Service: "test", Key: "c"},
 	}
 	for _, secret := range secrets {
-		store.Write(secret, "value")
+		store.Write(secret, "value", WriteOptions{})
 	}
 
 	t.Run("List should return all keys for a service", func(t *testing.T) {
-		s, err := store.List("test", false)
+		s, err := store.List("test", false, false)
 		assert.Nil(t, err)
 		assert.Equal(t, 3, len(s))
 		sort.Sort(ByKey(s))
@@ -396,7 +778,7 @@ func TestList(t *testing.T) {
 	})
 
 	t.Run("List should not return values if includeValues is false", func(t *testing.T) {
-		s, err := store.List("test", false)
+		s, err := store.List("test", false, false)
 		assert.Nil(t, err)
 		for _, secret := range s {
 			assert.Nil(t, secret.Value)
@@ -404,7 +786,7 @@ func TestList(t *testing.T) {
 	})
 
 	t.Run("List should return values if includeValues is true", func(t *testing.T) {
-		s, err := store.List("test", true)
+		s, err := store.List("test", true, false)
 		assert.Nil(t, err)
 		for _, secret := range s {
 			assert.Equal(t, "value", *secret.Value)
@@ -412,16 +794,89 @@ func TestList(t *testing.T) {
 	})
 
 	t.Run("List should only return exact matches on service name", func(t *testing.T) {
-		store.Write(SecretId{Service: "match", Key: "a"}, "val")
-		store.Write(SecretId{Service: "matchlonger", Key: "a"}, "val")
+		store.Write(SecretId{Service: "match", Key: "a"}, "val", WriteOptions{})
+		store.Write(SecretId{Service: "matchlonger", Key: "a"}, "val", WriteOptions{})
 
-		s, err := store.List("match", false)
+		s, err := store.List("match", false, false)
 		assert.Nil(t, err)
 		assert.Equal(t, 1, len(s))
 		assert.Equal(t, "match.a", s[0].Meta.Key)
 	})
 }
 
+func TestListAll(t *testing.T) {
+	for name, store := range map[string]*SSMStore{
+		"without paths": NewTestSSMStore(&mockSSMClient{parameters: map[string]mockParameter{}}),
+		"with paths":    NewTestSSMStoreWithPaths(&mockSSMClient{parameters: map[string]mockParameter{}}),
+	} {
+		secrets := []SecretId{
+			{Service: "api", Key: "stripe_key"},
+			{Service: "api-worker", Key: "stripe_key"},
+			{Service: "billing", Key: "db_password"},
+		}
+		for _, secret := range secrets {
+			store.Write(secret, secret.Service+"-value", WriteOptions{})
+		}
+
+		t.Run("ListAll should return secrets of every service "+name, func(t *testing.T) {
+			s, err := store.ListAll("", false)
+			assert.Nil(t, err)
+			assert.Equal(t, 3, len(s))
+			for _, secret := range s {
+				assert.Nil(t, secret.Value)
+			}
+		})
+
+		t.Run("ListAll should only return services matching the prefix "+name, func(t *testing.T) {
+			s, err := store.ListAll("api", true)
+			assert.Nil(t, err)
+			found := map[string]string{}
+			for _, secret := range s {
+				found[secret.Meta.Key] = *secret.Value
+			}
+			assert.Equal(t, map[string]string{
+				store.idToName(secrets[0]): "api-value",
+				store.idToName(secrets[1]): "api-worker-value",
+			}, found)
+		})
+	}
+}
+
+func TestListServices(t *testing.T) {
+	mock := &mockSSMClient{parameters: map[string]mockParameter{}}
+	store := NewTestSSMStoreWithPaths(mock)
+
+	secrets := []SecretId{
+		{Service: "api", Key: "a"},
+		{Service: "api", Key: "b"},
+		{Service: "billing", Key: "a"},
+		{Service: "worker", Key: "a"},
+	}
+	for _, secret := range secrets {
+		store.Write(secret, "value", WriteOptions{})
+	}
+
+	t.Run("ListServices should return every service with its key count", func(t *testing.T) {
+		s, err := store.ListServices("")
+		assert.Nil(t, err)
+		assert.Equal(t, 3, len(s))
+		assert.Equal(t, "api", s[0].Service)
+		assert.Equal(t, 2, s[0].Keys)
+		assert.Equal(t, "billing", s[1].Service)
+		assert.Equal(t, 1, s[1].Keys)
+		assert.Equal(t, "worker", s[2].Service)
+		assert.Equal(t, 1, s[2].Keys)
+		assert.Equal(t, *mock.parameters["/worker/a"].meta.LastModifiedDate, s[2].LastModified)
+	})
+
+	t.Run("ListServices should only return services matching the prefix", func(t *testing.T) {
+		s, err := store.ListServices("bill")
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(s))
+		assert.Equal(t, "billing", s[0].Service)
+	})
+}
+
 func TestListRaw(t *testing.T) {
 	mock := &mockSSMClient{parameters: map[string]mockParameter{}}
 	store := NewTestSSMStore(mock)
@@ -432,7 +887,7 @@ func TestListRaw(t *testing.T) {
 		{Service: "test", Key: "c"},
 	}
 	for _, secret := range secrets {
-		store.Write(secret, "value")
+		store.Write(secret, "value", WriteOptions{})
 	}
 
 	t.Run("ListRaw should return all keys and values for a service", func(t *testing.T) {
@@ -450,8 +905,8 @@ func TestListRaw(t *testing.T) {
 	})
 
 	t.Run("List should only return exact matches on service name", func(t *testing.T) {
-		store.Write(SecretId{Service: "match", Key: "a"}, "val")
-		store.Write(SecretId{Service: "matchlonger", Key: "a"}, "val")
+		store.Write(SecretId{Service: "match", Key: "a"}, "val", WriteOptions{})
+		store.Write(SecretId{Service: "matchlonger", Key: "a"}, "val", WriteOptions{})
 
 		s, err := store.ListRaw("match")
 		assert.Nil(t, err)
@@ -470,7 +925,7 @@ func TestListRawWithPaths(t *testing.T) {
 		{Service: "test", Key: "c"},
 	}
 	for _, secret := range secrets {
-		store.Write(secret, "value")
+		store.Write(secret, "value", WriteOptions{})
 	}
 
 	t.Run("ListRaw should return all keys and values for a service", func(t *testing.T) {
@@ -488,8 +943,8 @@ func TestListRawWithPaths(t *testing.T) {
 	})
 
 	t.Run("List should only return exact matches on service name", func(t *testing.T) {
-		store.Write(SecretId{Service: "match", Key: "a"}, "val")
-		store.Write(SecretId{Service: "matchlonger", Key: "a"}, "val")
+		store.Write(SecretId{Service: "match", Key: "a"}, "val", WriteOptions{})
+		store.Write(SecretId{Service: "matchlonger", Key: "a"}, "val", WriteOptions{})
 
 		s, err := store.ListRaw("match")
 		assert.Nil(t, err)
@@ -510,7 +965,7 @@ func TestHistory(t *testing.T) {
 	}
 
 	for _, s := range secrets {
-		store.Write(s, "value")
+		store.Write(s, "value", WriteOptions{})
 	}
 
 	t.Run("History for a non-existent key should return not found error", func(t *testing.T) {
@@ -542,13 +997,39 @@ func NewTestSSMStoreWithPaths(mock ssmiface.SSMAPI) *SSMStore {
 	}
 }
 
+func TestVersions(t *testing.T) {
+	mock := &mockSSMClient{parameters: map[string]mockParameter{}, historyPageSize: 2}
+	store := NewTestSSMStoreWithPaths(mock)
+	secretId := SecretId{Service: "test", Key: "key"}
+	large := strings.Repeat("x", MaxValueSize+1)
+	values := []string{"value 1", large, "value 3", "value 4", "value 5"}
+	for _, value := range values {
+		store.Write(secretId, value, WriteOptions{})
+	}
+
+	t.Run("Versions should return every version oldest first", func(t *testing.T) {
+		versions, err := store.Versions(secretId)
+		assert.Nil(t, err)
+		assert.Equal(t, len(values), len(versions))
+		for i, version := range versions {
+			assert.Equal(t, i+1, version.Meta.Version)
+			assert.Equal(t, values[i], *version.Value)
+		}
+	})
+
+	t.Run("Versions of a non-existent key should fail", func(t *testing.T) {
+		_, err := store.Versions(SecretId{Service: "test", Key: "nope"})
+		assert.NotNil(t, err)
+	})
+}
+
 func TestWritePaths(t *testing.T) {
 	mock := &mockSSMClient{parameters: map[string]mockParameter{}}
 	store := NewTestSSMStoreWithPaths(mock)
 
 	t.Run("Setting a new key should work", func(t *testing.T) {
 		secretId := SecretId{Service: "test", Key: "mykey"}
-		err := store.Write(secretId, "value")
+		err := store.Write(secretId, "value", WriteOptions{})
 		assert.Nil(t, err)
 		assert.Contains(t, mock.parameters, store.idToName(secretId))
 		assert.Equal(t, "value", *mock.parameters[store.idToName(secretId)].currentParam.Value)
@@ -557,9 +1038,9 @@ func TestWritePaths(t *testing.T) {
 
 	t.Run("Setting a key twice should create a new version", func(t *testing.T) {
 		secretId := SecretId{Service: "test", Key: "multipleversions"}
-		err := store.Write(secretId, "value")
+		err := store.Write(secretId, "value", WriteOptions{})
 		assert.Nil(t, err)
-		err = store.Write(secretId, "newvalue")
+		err = store.Write(secretId, "newvalue", WriteOptions{})
 		assert.Nil(t, err)
 
 		assert.Contains(t, mock.parameters, store.idToName(secretId))
@@ -573,9 +1054,9 @@ func TestReadPaths(t *testing.T) {
 	mock := &mockSSMClient{parameters: map[string]mockParameter{}}
 	store := NewTestSSMStoreWithPaths(mock)
 	secretId := SecretId{Service: "test", Key: "key"}
-	store.Write(secretId, "value")
-	store.Write(secretId, "second value")
-	store.Write(secretId, "third value")
+	store.Write(secretId, "value", WriteOptions{})
+	store.Write(secretId, "second value", WriteOptions{})
+	store.Write(secretId, "third value", WriteOptions{})
 
 	t.Run("Reading the latest value should work", func(t *testing.T) {
 		s, err := store.Read(secretId, -1)
@@ -618,11 +1099,11 @@ func TestListPaths(t *testing.T) {
 		{Service: "test", Key: "c"},
 	}
 	for _, secret := range secrets {
-		store.Write(secret, "value")
+		store.Write(secret, "value", WriteOptions{})
 	}
 
 	t.Run("List should return all keys for a service", func(t *testing.T) {
-		s, err := store.List("test", false)
+		s, err := store.List("test", false, false)
 		assert.Nil(t, err)
 		assert.Equal(t, 3, len(s))
 		sort.Sort(ByKey(s))
@@ -632,7 +1113,7 @@ func TestListPaths(t *testing.T) {
 	})
 
 	t.Run("List should not return values if includeValues is false", func(t *testing.T) {
-		s, err := store.List("test", false)
+		s, err := store.List("test", false, false)
 		assert.Nil(t, err)
 		for _, secret := range s {
 			assert.Nil(t, secret.Value)
@@ -640,7 +1121,7 @@ func TestListPaths(t *testing.T) {
 	})
 
 	t.Run("List should return values if includeValues is true", func(t *testing.T) {
-		s, err := store.List("test", true)
+		s, err := store.List("test", true, false)
 		assert.Nil(t, err)
 		for _, secret := range s {
 			assert.Equal(t, "value", *secret.Value)
@@ -648,14 +1129,60 @@ func TestListPaths(t *testing.T) {
 	})
 
 	t.Run("List should only return exact matches on service name", func(t *testing.T) {
-		store.Write(SecretId{Service: "match", Key: "a"}, "val")
-		store.Write(SecretId{Service: "matchlonger", Key: "a"}, "val")
+		store.Write(SecretId{Service: "match", Key: "a"}, "val", WriteOptions{})
+		store.Write(SecretId{Service: "matchlonger", Key: "a"}, "val", WriteOptions{})
 
-		s, err := store.List("match", false)
+		s, err := store.List("match", false, false)
 		assert.Nil(t, err)
 		assert.Equal(t, 1, len(s))
 		assert.Equal(t, "/match/a", s[0].Meta.Key)
 	})
+
+	t.Run("List should return tags if includeTags is true", func(t *testing.T) {
+		store.Write(SecretId{Service: "tagged", Key: "a"}, "val", WriteOptions{
+			Description: "first",
+			Tags:        map[string]string{"team": "infra"},
+		})
+
+		s, err := store.List("tagged", false, true)
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(s))
+		assert.Equal(t, "first", s[0].Meta.Description)
+		assert.Equal(t, map[string]string{"team": "infra"}, s[0].Meta.Tags)
+	})
+}
+
+func TestReadManyPaths(t *testing.T) {
+	mock := &mockSSMClient{parameters: map[string]mockParameter{}}
+	store := NewTestSSMStoreWithPaths(mock)
+	ids := []SecretId{}
+	for i := 0; i < 60; i++ {
+		id := SecretId{Service: "test", Key: fmt.Sprintf("key%02d", i)}
+		store.Write(id, fmt.Sprintf("value%02d", i), WriteOptions{})
+		ids = append(ids, id)
+	}
+
+	t.Run("Reading a key should not describe the rest of its service", func(t *testing.T) {
+		mock.described = 0
+		s, err := store.Read(ids[7], -1)
+		assert.Nil(t, err)
+		assert.Equal(t, "value07", *s.Value)
+		assert.Equal(t, 1, s.Meta.Version)
+		assert.Equal(t, 1, mock.described)
+	})
+
+	t.Run("Reading many keys should return those that exist", func(t *testing.T) {
+		missing := SecretId{Service: "test", Key: "nope"}
+		secrets, err := store.ReadMany(append(ids, missing))
+		assert.Nil(t, err)
+		assert.Equal(t, len(ids), len(secrets))
+		for i, id := range ids {
+			assert.Equal(t, fmt.Sprintf("value%02d", i), *secrets[id].Value)
+			assert.Equal(t, "/test/"+id.Key, secrets[id].Meta.Key)
+		}
+		_, ok := secrets[missing]
+		assert.False(t, ok)
+	})
 }
 
 func TestHistoryPaths(t *testing.T) {
@@ -670,7 +1197,7 @@ func TestHistoryPaths(t *testing.T) {
 	}
 
 	for _, s := range secrets {
-		store.Write(s, "value")
+		store.Write(s, "value", WriteOptions{})
 	}
 
 	t.Run("History for a non-existent key should return not found error", func(t *testing.T) {
@@ -700,7 +1227,7 @@ func TestDelete(t *testing.T) {
 	store := NewTestSSMStore(mock)
 
 	secretId := SecretId{Service: "test", Key: "key"}
-	store.Write(secretId, "value")
+	store.Write(secretId, "value", WriteOptions{})
 
 	t.Run("Deleting secret should work", func(t *testing.T) {
 		err := store.Delete(secretId)
@@ -715,6 +1242,149 @@ func TestDelete(t *testing.T) {
 	})
 }
 
+func TestSoftDelete(t *testing.T) {
+	for name, store := range map[string]*SSMStore{
+		"without paths": NewTestSSMStore(&mockSSMClient{parameters: map[string]mockParameter{}}),
+		"with paths":    NewTestSSMStoreWithPaths(&mockSSMClient{parameters: map[string]mockParameter{}}),
+	} {
+		secretId := SecretId{Service: "test", Key: "key"}
+		store.Write(secretId, "value", WriteOptions{Tags: map[string]string{"owner": "payments"}})
+		store.Write(secretId, "second value", WriteOptions{})
+
+		t.Run("Soft deleting a secret should move it to the recycle bin "+name, func(t *testing.T) {
+			err := store.SoftDelete(secretId)
+			assert.Nil(t, err)
+
+			_, err = store.Read(secretId, -1)
+			assert.Equal(t, ErrSecretNotFound, err)
+
+			deleted, err := store.ListDeleted()
+			assert.Nil(t, err)
+			assert.Equal(t, 1, len(deleted))
+			assert.Equal(t, secretId, deleted[0].Id)
+		})
+
+		t.Run("Undeleting a secret should restore all versions and tags "+name, func(t *testing.T) {
+			err := store.Undelete(secretId)
+			assert.Nil(t, err)
+
+			first, err := store.Read(secretId, 1)
+			assert.Nil(t, err)
+			assert.Equal(t, "value", *first.Value)
+
+			second, err := store.Read(secretId, -1)
+			assert.Nil(t, err)
+			assert.Equal(t, "second value", *second.Value)
+			assert.Equal(t, 2, second.Meta.Version)
+			assert.Equal(t, map[string]string{"owner": "payments"}, second.Meta.Tags)
+
+			deleted, err := store.ListDeleted()
+			assert.Nil(t, err)
+			assert.Equal(t, 0, len(deleted))
+		})
+
+		t.Run("Undeleting a secret that is not in the recycle bin should fail "+name, func(t *testing.T) {
+			err := store.Undelete(SecretId{Service: "test", Key: "nope"})
+			assert.Equal(t, ErrSecretNotFound, err)
+		})
+
+		t.Run("Undeleting over an existing secret should fail "+name, func(t *testing.T) {
+			err := store.SoftDelete(secretId)
+			assert.Nil(t, err)
+			store.Write(secretId, "new value", WriteOptions{})
+
+			err = store.Undelete(secretId)
+			assert.Equal(t, ErrSecretExists, err)
+		})
+
+		t.Run("Purging a secret should remove it from the recycle bin "+name, func(t *testing.T) {
+			deleted, err := store.ListDeleted()
+			assert.Nil(t, err)
+			assert.Equal(t, 1, len(deleted))
+
+			err = store.Purge(deleted[0])
+			assert.Nil(t, err)
+
+			deleted, err = store.ListDeleted()
+			assert.Nil(t, err)
+			assert.Equal(t, 0, len(deleted))
+		})
+	}
+}
+
+func TestSoftDeleteHistory(t *testing.T) {
+	for name, store := range map[string]*SSMStore{
+		"without paths": NewTestSSMStore(&mockSSMClient{parameters: map[string]mockParameter{}, historyPageSize: 2}),
+		"with paths":    NewTestSSMStoreWithPaths(&mockSSMClient{parameters: map[string]mockParameter{}, historyPageSize: 2}),
+	} {
+		mock := store.svc.(*mockSSMClient)
+		secretId := SecretId{Service: "test", Key: "key"}
+		for i := 1; i <= 7; i++ {
+			store.Write(secretId, fmt.Sprintf("value %d", i), WriteOptions{})
+		}
+
+		t.Run("Every page of versions should survive a soft delete "+name, func(t *testing.T) {
+			assert.Nil(t, store.SoftDelete(secretId))
+			assert.Nil(t, store.Undelete(secretId))
+
+			for i := 1; i <= 7; i++ {
+				s, err := store.Read(secretId, i)
+				assert.Nil(t, err)
+				assert.Equal(t, fmt.Sprintf("value %d", i), *s.Value)
+			}
+			events, err := store.History(secretId)
+			assert.Nil(t, err)
+			assert.Equal(t, 7, len(events))
+		})
+
+		t.Run("Soft deleting twice in a second should keep both copies "+name, func(t *testing.T) {
+			assert.Nil(t, store.SoftDelete(secretId))
+			store.Write(secretId, "value", WriteOptions{})
+			assert.Nil(t, store.SoftDelete(secretId))
+
+			deleted, err := store.ListDeleted()
+			assert.Nil(t, err)
+			assert.Equal(t, 2, len(deleted))
+			assert.NotEqual(t, deleted[0].DeletedAt, deleted[1].DeletedAt)
+			for _, d := range deleted {
+				assert.Nil(t, store.Purge(d))
+			}
+		})
+
+		t.Run("Failing to read the history should keep the secret "+name, func(t *testing.T) {
+			store.Write(secretId, "value", WriteOptions{})
+			denied := awserr.New("AccessDeniedException", "not authorized to perform: ssm:GetParameterHistory", nil)
+			mock.historyErr = denied
+			defer func() { mock.historyErr = nil }()
+
+			assert.Equal(t, denied, store.SoftDelete(secretId))
+			_, err := store.readLatest(secretId)
+			assert.Nil(t, err)
+			deleted, err := store.ListDeleted()
+			assert.Nil(t, err)
+			assert.Equal(t, 0, len(deleted))
+		})
+	}
+}
+
+func TestParseDeletedTime(t *testing.T) {
+	deletedAt := time.Date(2018, 3, 4, 5, 6, 7, 89, time.UTC)
+	store := NewTestSSMStoreWithPaths(&mockSSMClient{parameters: map[string]mockParameter{}})
+	name := store.deletedName(DeletedSecret{Id: SecretId{Service: "test", Key: "key"}, DeletedAt: deletedAt})
+	assert.Equal(t, "/_deleted/20180304T050607000000089Z/test/key", name)
+
+	deleted, ok := store.parseDeletedName(name)
+	assert.True(t, ok)
+	assert.Equal(t, deletedAt, deleted.DeletedAt)
+
+	deleted, ok = store.parseDeletedName("/_deleted/20180304T050607Z/test/key")
+	assert.True(t, ok)
+	assert.Equal(t, time.Date(2018, 3, 4, 5, 6, 7, 0, time.UTC), deleted.DeletedAt)
+
+	_, ok = store.parseDeletedName("/_deleted/20180304T050607x00000089Z/test/key")
+	assert.False(t, ok)
+}
+
 type ByKey []Secret
 
 func (a ByKey) Len() int           { return len(a) }
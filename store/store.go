@@ -26,6 +26,18 @@ var (
 	// ErrSecretNotFound is returned if the specified secret is not found in the
 	// parameter store
 	ErrSecretNotFound = errors.New("secret not found")
+
+	// ErrSecretExists is returned if a secret is restored over a secret that
+	// already exists
+	ErrSecretExists = errors.New("secret already exists")
+)
+
+// Parameter types a secret can be stored as.  Only SecureString values are
+// encrypted.
+const (
+	TypeSecureString = "SecureString"
+	TypeString       = "String"
+	TypeStringList   = "StringList"
 )
 
 type SecretId struct {
@@ -42,13 +54,56 @@ type Secret struct {
 type RawSecret struct {
 	Value string
 	Key   string
+	Type  string
 }
 
 type SecretMetadata struct {
-	Created   time.Time
-	CreatedBy string
-	Version   int
-	Key       string
+	Created     time.Time
+	CreatedBy   string
+	Version     int
+	Key         string
+	Description string
+	Tags        map[string]string
+	// KMSKey is the KMS key the latest version is encrypted with
+	KMSKey string
+	// Type is the parameter type of the secret, e.g. TypeSecureString
+	Type string
+	// TagsDenied is set when the description and tags were requested but
+	// could not be read, for lack of the ssm:ListTagsForResource permission
+	TagsDenied bool
+}
+
+// WriteOptions holds the optional metadata that can be attached to a secret
+// when it is written.  Tags and the description are attached to the secret
+// itself rather than to a single version, so leaving them empty preserves
+// whatever was set by a previous write.
+type WriteOptions struct {
+	Description string
+	Tags        map[string]string
+	// KMSKey is the KMS key to encrypt the value with, instead of the
+	// store's default key
+	KMSKey string
+	// DefaultKMSKey is the KMS key to encrypt the value with when KMSKey is
+	// empty.  Unlike KMSKey, it is ignored when the secret isn't stored as
+	// TypeSecureString, so that it can be given without knowing the type of
+	// an existing secret.
+	DefaultKMSKey string
+	// Type is the parameter type to store the value as.  If empty, the type
+	// of an existing secret is kept and new secrets are TypeSecureString.
+	Type string
+}
+
+// ServiceSummary describes a service namespace present in the store
+type ServiceSummary struct {
+	Service      string
+	Keys         int
+	LastModified time.Time
+}
+
+// DeletedSecret identifies a soft deleted secret in the recycle bin
+type DeletedSecret struct {
+	Id        SecretId
+	DeletedAt time.Time
 }
 
 type ChangeEvent struct {
@@ -59,10 +114,18 @@ type ChangeEvent struct {
 }
 
 type Store interface {
-	Write(id SecretId, value string) error
+	Write(id SecretId, value string, opts WriteOptions) error
 	Read(id SecretId, version int) (Secret, error)
-	List(service string, includeValues bool) ([]Secret, error)
+	ReadMany(ids []SecretId) (map[SecretId]Secret, error)
+	List(service string, includeValues bool, includeTags bool) ([]Secret, error)
 	ListRaw(service string) ([]RawSecret, error)
+	ListAll(servicePrefix string, includeValues bool) ([]Secret, error)
+	ListServices(servicePrefix string) ([]ServiceSummary, error)
 	History(id SecretId) ([]ChangeEvent, error)
+	Versions(id SecretId) ([]Secret, error)
 	Delete(id SecretId) error
+	SoftDelete(id SecretId) error
+	Undelete(id SecretId) error
+	ListDeleted() ([]DeletedSecret, error)
+	Purge(deleted DeletedSecret) error
 }